@@ -0,0 +1,108 @@
+package main
+
+import (
+	"authz/rulebase"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+//Request headers given as repeated -header "Name: value" flags
+type headers http.Header
+
+func (h headers) String() string {
+	return fmt.Sprint(http.Header(h))
+}
+
+func (h headers) Set(s string) error {
+	i := strings.IndexByte(s, ':')
+	if i < 0 {
+		return errors.New("a header must be given as Name: value")
+	}
+	http.Header(h).Add(strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]))
+	return nil
+}
+
+//Loads a configuration file and explains the decision for one request. Exits with 0 if the
+//request is allowed, 1 if it is denied and 2 on errors.
+func check(args []string) int {
+	var config string
+	header := headers{}
+
+	fs := flags("check", &config)
+	subject := fs.String("subject", "", "subject of the request")
+	method := fs.String("method", "GET", "HTTP method of the request")
+	url := fs.String("url", "", "host and URI of the request")
+	remote_addr := fs.String("remote-addr", "", "address of the client")
+	fs.Var(header, "header", "request header as \"Name: value\", can be repeated")
+	fs.Parse(args)
+
+	if *url == "" {
+		fmt.Fprintf(os.Stderr, "authz check: -url is required\n")
+		fs.Usage()
+		return 2
+	}
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	d, err := rb.Explain(&rulebase.Request{Subject: *subject, Method: *method, Url: *url, Header: http.Header(header), RemoteAddr: *remote_addr})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz check: %s\n", err)
+		return 2
+	}
+
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	fmt.Printf("%s %s %s for %s\n", decision, strings.ToUpper(*method), d.Url, *subject)
+
+	switch {
+	case d.Unreachable:
+		fmt.Printf("  client %s is outside the networks %s is restricted to\n", *remote_addr, d.Url)
+	case d.Rule == "":
+		if d.UnknownHost {
+			fmt.Printf("  rule:         none, unknown host policy applies\n")
+		} else {
+			fmt.Printf("  rule:         none, default policy applies\n")
+		}
+	default:
+		kind := ""
+		if d.Conditional {
+			kind = " (conditional)"
+		}
+		fmt.Printf("  rule:         %s%s\n", d.Rule, kind)
+		if d.Inherited != "" {
+			fmt.Printf("  inherited:    %s\n", d.Inherited)
+		}
+		if len(d.Groups) > 0 {
+			fmt.Printf("  groups:       %s\n", strings.Join(d.Groups, ", "))
+		}
+		for _, g := range d.Grants {
+			fmt.Printf("  grant:        %s\n", g)
+		}
+	}
+	if !d.Unreachable {
+		fmt.Printf("  default:      %s\n", verbs(d.Default))
+	}
+	fmt.Printf("  access:       %s\n", verbs(d.Access))
+
+	if !d.Allowed {
+		return 1
+	}
+	return 0
+}
+
+//Returns the verbs of access_flags separated by spaces, or "none"
+func verbs(access_flags int) string {
+	if access_flags == 0 {
+		return "none"
+	}
+	return strings.Join(rulebase.Verbs(access_flags), " ")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Loads a configuration file and writes a snapshot of the rulebase, which authz loads much
+//faster than the configuration. Exits with 2 on errors.
+func compile(args []string) int {
+	var config string
+
+	fs := flags("compile", &config)
+	output := fs.String("o", "conf.snap", "snapshot file to write")
+	fs.Parse(args)
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz compile: %s\n", err)
+		return 2
+	}
+	err = rb.WriteSnapshot(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz compile: %s\n", err)
+		os.Remove(*output)
+		return 2
+	}
+
+	return 0
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"authz/rulebase"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//Compares the access two configuration files grant and prints the verbs each subject and group
+//gains or loses per URL. Exits with 0 if nothing changed, 1 if something did and 2 on errors.
+func diff(args []string) int {
+	fs := flag.NewFlagSet("authz diff", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: authz diff old.yml new.yml\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2
+	}
+
+	var confs [2]*rulebase.Config
+	for i, filename := range fs.Args() {
+		conf, err := rulebase.Readconfig(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", filename, err)
+			return 2
+		}
+		confs[i] = conf
+	}
+
+	changes, err := rulebase.Diff(confs[0], confs[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz diff: %s\n", err)
+		return 2
+	}
+
+	for _, c := range changes {
+		kind := "subject"
+		if c.Group {
+			kind = "group"
+		}
+		var verbs []string
+		for _, v := range rulebase.Verbs(c.Added) {
+			verbs = append(verbs, "+"+v)
+		}
+		for _, v := range rulebase.Verbs(c.Removed) {
+			verbs = append(verbs, "-"+v)
+		}
+		fmt.Printf("%s %s %s: %s\n", kind, c.Subject, c.Url, strings.Join(verbs, " "))
+	}
+
+	if len(changes) > 0 {
+		return 1
+	}
+	return 0
+}
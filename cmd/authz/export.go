@@ -0,0 +1,32 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Loads a configuration file and prints the configuration of the rulebase in canonical form.
+//Exits with 2 on errors.
+func export(args []string) int {
+	var config string
+
+	fs := flags("export", &config)
+	format := fs.String("format", "yaml", "format of the exported configuration, yaml, json or toml")
+	fs.Parse(args)
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	data, err := rb.Export().Encode(*format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz export: %s\n", err)
+		return 2
+	}
+	os.Stdout.Write(data)
+
+	return 0
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Loads a configuration file and warns about risky and pointless definitions. Exits with 1 if
+//there are warnings and 2 if the configuration can't be loaded.
+func lint(args []string) int {
+	var config string
+
+	fs := flags("lint", &config)
+	fs.Parse(args)
+
+	warnings, err := rulebase.Lint(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	for _, w := range warnings {
+		fmt.Println(w)
+	}
+	if len(warnings) > 0 {
+		return 1
+	}
+	return 0
+}
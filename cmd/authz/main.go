@@ -0,0 +1,83 @@
+//Command authz is the command line interface to authz's rulebases.
+//
+//Usage:
+//
+//	authz <command> [flags]
+//
+//The commands are:
+//
+//	check       explain the decision for a request and exit non-zero if it is denied
+//	compile     write a snapshot of a rulebase that loads faster than its configuration
+//	diff        show the verbs subjects and groups gain or lose between two configurations
+//	export      print the configuration of a rulebase in canonical form
+//	lint        warn about risky and pointless rules, groups and subjects
+//	replay      report the logged requests whose decision a configuration would change
+//	serve       answer forward authorization requests
+//	test        run the policy tests of a configuration and of tests files
+//	validate    load a configuration file and report errors and expired grants
+//	what        list the URLs a subject has access to
+//	who         list the subjects and groups with access to a URL
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	run   func(args []string) int
+	usage string
+}
+
+var commands = map[string]command{
+	"check":    {check, "explain the decision for a request and exit non-zero if it is denied"},
+	"compile":  {compile, "write a snapshot of a rulebase that loads faster than its configuration"},
+	"diff":     {diff, "show the verbs subjects and groups gain or lose between two configurations"},
+	"export":   {export, "print the configuration of a rulebase in canonical form"},
+	"lint":     {lint, "warn about risky and pointless rules, groups and subjects"},
+	"replay":   {replay, "report the logged requests whose decision a configuration would change"},
+	"serve":    {serve, "answer forward authorization requests"},
+	"test":     {test, "run the policy tests of a configuration and of tests files"},
+	"validate": {validate, "load a configuration file and report errors and expired grants"},
+	"what":     {what, "list the URLs a subject has access to"},
+	"who":      {who, "list the subjects and groups with access to a URL"},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: authz <command> [flags]\n\nThe commands are:\n\n")
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s  %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'authz <command> -h' for the flags of a command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, exists := commands[os.Args[1]]
+	if !exists {
+		fmt.Fprintf(os.Stderr, "authz: unknown command %s\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	os.Exit(cmd.run(os.Args[2:]))
+}
+
+//Returns a flag set for the command name with the -config flag every command takes
+func flags(name string, config *string) *flag.FlagSet {
+	fs := flag.NewFlagSet("authz "+name, flag.ExitOnError)
+	fs.StringVar(config, "config", "conf.yml", "configuration file")
+	return fs
+}
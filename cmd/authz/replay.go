@@ -0,0 +1,82 @@
+package main
+
+import (
+	"authz/rulebase"
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+//Replays NGINX access logs or logs of decisions against a candidate configuration and prints
+//the requests whose decision would change, grouped by subject and URL prefix. Exits with 0 if no
+//decision changes, 1 if any does and 2 on errors.
+func replay(args []string) int {
+	var config string
+
+	fs := flags("replay", &config)
+	host := fs.String("host", "", "host of the requests in access logs that don't log absolute URLs")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fmt.Fprintf(os.Stderr, "authz replay: no log files given\n")
+		fs.Usage()
+		return 2
+	}
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	r := rulebase.NewReplay(rb)
+	skipped := 0
+	for _, filename := range fs.Args() {
+		f, err := os.Open(filename)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "authz replay: %s\n", err)
+			return 2
+		}
+
+		jsonl := filepath.Ext(filename) == ".jsonl" || filepath.Ext(filename) == ".json"
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" {
+				continue
+			}
+			var req *rulebase.LoggedRequest
+			if jsonl || strings.HasPrefix(line, "{") {
+				req, err = rulebase.ParseDecisionLine(line)
+			} else {
+				req, err = rulebase.ParseAccessLogLine(line, *host)
+			}
+			if err != nil {
+				skipped++
+				continue
+			}
+			r.Add(req)
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "authz replay: %s: %s\n", filename, err)
+			return 2
+		}
+	}
+
+	changed := 0
+	for _, d := range r.Divergences() {
+		fmt.Printf("%s %s: %d granted, %d revoked (e.g. %s %s)\n", d.Subject, d.Prefix, d.Granted, d.Revoked, strings.ToUpper(d.Example.Method), d.Example.Url)
+		changed += d.Granted + d.Revoked
+	}
+	fmt.Printf("replayed %d requests: %d decisions changed, %d could not be evaluated, %d with unsupported methods, %d lines skipped\n", r.Requests, changed, r.Errors, r.Unsupported, skipped)
+
+	if changed > 0 {
+		return 1
+	}
+	return 0
+}
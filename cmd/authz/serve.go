@@ -0,0 +1,201 @@
+package main
+
+import (
+	"authz/rulebase"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+//Anything requests can be authorized with: a rulebase, the shadow evaluation of a candidate
+//next to one or a registry of tenants
+type authorizer interface {
+	Authorize(req *rulebase.Request) (int, error)
+}
+
+//Answers forward authorization requests with the current authorizer
+type server struct {
+	lock           sync.RWMutex
+	authorizer     authorizer
+	subject_header string
+}
+
+//Serves forward authorization requests, like those of NGINX's auth_request, with the rulebase
+//of a configuration or of the tenants of a registry. The disagreements of a candidate, or of
+//the candidates of the tenants, evaluated in the shadow of the enforcing rulebases are logged.
+//SIGHUP reloads the configurations. Exits with 2 on errors.
+func serve(args []string) int {
+	var config string
+
+	fs := flags("serve", &config)
+	candidate := fs.String("candidate", "", "configuration evaluated in the shadow of the configuration")
+	registry := fs.String("registry", "", "registry of tenants to serve instead of the configuration")
+	listen := fs.String("listen", ":8080", "address to listen on")
+	subject_header := fs.String("subject-header", "X-Remote-User", "request header with the authenticated subject")
+	fs.Parse(args)
+
+	log.SetPrefix("authz serve: ")
+	s := &server{subject_header: *subject_header}
+
+	var reload func() error
+	if *registry != "" {
+		if *candidate != "" {
+			fmt.Fprintf(os.Stderr, "authz serve: -candidate can't be used with -registry, set the Candidate of tenants instead\n")
+			return 2
+		}
+		r, err := rulebase.LoadRegistry(*registry)
+		if r == nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", *registry, err)
+			return 2
+		} else if err != nil {
+			//the tenants that failed refuse their requests, the others are served
+			log.Print(err)
+		}
+		r.SetShadowLog(disagreement)
+		s.authorizer = r
+
+		reload = func() error {
+			var failed []string
+			for _, err := range r.ReloadAll() {
+				failed = append(failed, err.Error())
+			}
+			if len(failed) > 0 {
+				sort.Strings(failed)
+				return errors.New(strings.Join(failed, "\n"))
+			}
+			return nil
+		}
+	} else {
+		reload = func() error {
+			a, err := load(config, *candidate)
+			if err != nil {
+				return err
+			}
+			s.swap(a)
+			return nil
+		}
+		if err := reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "authz serve: %s\n", err)
+			return 2
+		}
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := reload(); err != nil {
+				log.Printf("reload failed, the last good configurations are kept: %s", err)
+			}
+		}
+	}()
+
+	err := http.ListenAndServe(*listen, s)
+	fmt.Fprintf(os.Stderr, "authz serve: %s\n", err)
+	return 2
+}
+
+//Loads the rulebase of config and, if candidate isn't "", evaluates the rulebase of candidate
+//in its shadow
+func load(config string, candidate string) (authorizer, error) {
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", config, err))
+	}
+	if candidate == "" {
+		return rb, nil
+	}
+
+	c, err := rulebase.Load(candidate)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", candidate, err))
+	}
+	return rulebase.NewShadow(rb, c, func(d *rulebase.Disagreement) {
+		disagreement("", d)
+	}), nil
+}
+
+//Replaces the authorizer and stops the shadow evaluation of the previous one, if it has one
+func (s *server) swap(a authorizer) {
+	s.lock.Lock()
+	previous := s.authorizer
+	s.authorizer = a
+	s.lock.Unlock()
+
+	if shadow, ok := previous.(*rulebase.Shadow); ok {
+		shadow.Close()
+	}
+}
+
+//Answers a forward authorization request with 200 if the original request is allowed and 403
+//if it is denied or can't be evaluated
+func (s *server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := original(r, s.subject_header)
+
+	s.lock.RLock()
+	a := s.authorizer
+	s.lock.RUnlock()
+
+	access_flags, err := a.Authorize(req)
+	if err != nil {
+		log.Printf("%s %s %s: %s", req.Subject, req.Method, req.Url, err)
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	method, err := rulebase.AccessFlags([]string{strings.ToUpper(req.Method)})
+	if err != nil || access_flags&method == 0 {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+//Returns the original request a forward authorization request is made for. Its URL is taken
+//from the X-Original-Host (or Host) and X-Original-URI headers, its method from
+//X-Original-Method and its subject from subject_header, anonymous if there is none. Missing
+//headers are taken from the forward authorization request itself.
+func original(r *http.Request, subject_header string) *rulebase.Request {
+	host := r.Header.Get("X-Original-Host")
+	if host == "" {
+		host = r.Host
+	}
+	uri := r.Header.Get("X-Original-URI")
+	if uri == "" {
+		uri = r.URL.RequestURI()
+	}
+	method := r.Header.Get("X-Original-Method")
+	if method == "" {
+		method = r.Method
+	}
+	subject := r.Header.Get(subject_header)
+	if subject == "" {
+		subject = "anonymous"
+	}
+
+	return &rulebase.Request{Subject: subject, Method: method, Url: host + uri, Header: r.Header, RemoteAddr: r.RemoteAddr}
+}
+
+//Logs a disagreement of the candidate of tenant, "" if there are no tenants, with the
+//enforcing rulebase
+func disagreement(tenant string, d *rulebase.Disagreement) {
+	shadow := "shadow"
+	if tenant != "" {
+		shadow += " of " + tenant
+	}
+	log.Printf("%s: %s %s %s: enforced %s, candidate %s", shadow, d.Request.Subject, strings.ToUpper(d.Request.Method), d.Request.Url, decision(d.Enforced, d.EnforcedErr), decision(d.Candidate, d.Err))
+}
+
+//Returns the verbs of access_flags, or err if evaluating the request failed
+func decision(access_flags int, err error) string {
+	if err != nil {
+		return "error (" + err.Error() + ")"
+	}
+	return "[" + strings.Join(rulebase.Verbs(access_flags), " ") + "]"
+}
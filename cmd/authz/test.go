@@ -0,0 +1,54 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Loads a configuration file and runs the policy tests of its tests section and of the tests
+//files given as arguments. Exits with 0 if all tests pass, 1 if any fails and 2 on errors.
+func test(args []string) int {
+	var config string
+
+	fs := flags("test", &config)
+	fs.Parse(args)
+
+	conf, err := rulebase.Readconfig(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+	rb, err := rulebase.Build(conf)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	suites := []*rulebase.Config{conf}
+	for _, filename := range fs.Args() {
+		tests, err := rulebase.ReadTests(filename)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		suites = append(suites, tests)
+	}
+
+	total, failed := 0, 0
+	for _, s := range suites {
+		failures := rb.RunTests(s)
+		for _, f := range failures {
+			fmt.Println(f)
+		}
+		total += len(s.Tests)
+		failed += len(failures)
+	}
+
+	if failed > 0 {
+		fmt.Printf("FAIL: %d of %d tests failed\n", failed, total)
+		return 1
+	}
+	fmt.Printf("ok: %d tests passed\n", total)
+	return 0
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Validates a configuration file, reporting every problem with its position, and loads it.
+//Expired grants are reported as warnings; with -strict they make validation fail.
+func validate(args []string) int {
+	var config string
+
+	fs := flags("validate", &config)
+	strict := fs.Bool("strict", false, "fail if the configuration contains expired grants")
+	fs.Parse(args)
+
+	diagnostics := rulebase.Validate(config)
+	for _, d := range diagnostics {
+		fmt.Fprintln(os.Stderr, d)
+	}
+	if len(diagnostics) > 0 {
+		return 1
+	}
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 1
+	}
+
+	expired := rb.ExpiredGrants()
+	for _, g := range expired {
+		fmt.Printf("%s: warning: grant for %v on %s expired on %s\n", config, g.Subjects, g.Url, g.NotAfter.Format("2006-01-02 15:04 MST"))
+	}
+
+	if *strict && len(expired) > 0 {
+		return 1
+	}
+	return 0
+}
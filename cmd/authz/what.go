@@ -0,0 +1,35 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+)
+
+//Loads a configuration file and lists every rule URL a subject has access to with the verbs it
+//is granted. Exits with 2 on errors.
+func what(args []string) int {
+	var config string
+
+	fs := flags("what", &config)
+	subject := fs.String("subject", "", "subject or group to list the access of")
+	fs.Parse(args)
+
+	if *subject == "" {
+		fmt.Fprintf(os.Stderr, "authz what: -subject is required\n")
+		fs.Usage()
+		return 2
+	}
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	for _, p := range rb.WhatCan(*subject) {
+		fmt.Printf("%-40s %s\n", p.Url, grant(p.Access, p.Conditional, p.Groups, p.Inherited, nil))
+	}
+
+	return 0
+}
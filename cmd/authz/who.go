@@ -0,0 +1,85 @@
+package main
+
+import (
+	"authz/rulebase"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//Loads a configuration file and lists every subject and group with access to a URL, or with
+//access to it with one verb. Exits with 2 on errors.
+func who(args []string) int {
+	var config string
+
+	fs := flags("who", &config)
+	url := fs.String("url", "", "host and URI to list the subjects and groups with access for")
+	verb := fs.String("verb", "", "only list subjects and groups that are granted this HTTP verb")
+	fs.Parse(args)
+
+	if *url == "" {
+		fmt.Fprintf(os.Stderr, "authz who: -url is required\n")
+		fs.Usage()
+		return 2
+	}
+	filter := rulebase.GET | rulebase.PUT | rulebase.POST | rulebase.DELETE | rulebase.UPDATE
+	if *verb != "" {
+		flags, err := rulebase.AccessFlags([]string{strings.ToUpper(*verb)})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "authz who: %s", err)
+			return 2
+		}
+		filter = flags
+	}
+
+	rb, err := rulebase.Load(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", config, err)
+		return 2
+	}
+
+	a, err := rb.WhoCan(*url)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "authz who: %s\n", err)
+		return 2
+	}
+
+	rule := "no rule"
+	if a.Rule != "" {
+		rule = "rule " + a.Rule
+	}
+	fmt.Printf("%s (%s)\n", a.Url, rule)
+	if a.Default&filter != 0 {
+		fmt.Printf("  %-12s %s (default policy)\n", "everybody", verbs(a.Default))
+	}
+	for _, g := range a.Grantees {
+		if (g.Access|g.Conditional)&filter == 0 {
+			continue
+		}
+		var notes []string
+		if g.Group {
+			notes = append(notes, "group")
+		}
+		fmt.Printf("  %-12s %s\n", g.Name, grant(g.Access, g.Conditional, g.Groups, g.Inherited, notes))
+	}
+
+	return 0
+}
+
+//Describes access granted to a subject or group: the verbs and where they come from
+func grant(access_flags int, conditional int, groups []string, inherited string, notes []string) string {
+	if len(groups) > 0 {
+		notes = append(notes, "through "+strings.Join(groups, ", "))
+	}
+	if inherited != "" {
+		notes = append(notes, "inherited from "+inherited)
+	}
+	if conditional != 0 {
+		notes = append(notes, "conditionally "+verbs(conditional))
+	}
+
+	if len(notes) > 0 {
+		return verbs(access_flags) + " (" + strings.Join(notes, "; ") + ")"
+	}
+	return verbs(access_flags)
+}
@@ -0,0 +1,218 @@
+package prefixtree
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+//arrayTree is the trie prefixtree used before it was turned into a radix tree: every node has
+//a fixed array with a slot for each possible byte. It is kept as a reference for the
+//benchmarks and to check that both implementations match the same way.
+type arrayTree struct {
+	root *arrayNode
+}
+
+type arrayNode struct {
+	wildcard bool
+	child    [256]*arrayNode
+	value    map[string]int
+}
+
+func newArrayTree() *arrayTree {
+	return &arrayTree{root: new(arrayNode)}
+}
+
+func (t arrayTree) AddKey(prefix string, key string, value int) {
+	n := t.root
+
+	for i := 0; i < len(prefix); i++ {
+		p := prefix[i]
+		if p == '*' {
+			n.wildcard = true
+		} else {
+			if n.child[p] == nil {
+				n.child[p] = new(arrayNode)
+			}
+			n = n.child[p]
+		}
+	}
+
+	if n.value == nil {
+		n.value = make(map[string]int)
+	}
+	n.value[key] = value
+}
+
+func (t arrayTree) Match(prefix string, key string) (int, error) {
+	var wildcard *arrayNode
+	n := t.root
+
+	for p := 0; n != nil && p < len(prefix); p++ {
+		if n.wildcard {
+			wildcard = n
+		}
+		n = n.child[prefix[p]]
+	}
+
+	if n == nil {
+		n = wildcard
+	}
+	if n == nil {
+		return 0, errors.New("prefix does not match")
+	}
+	v, exists := n.value[key]
+	if !exists {
+		return 0, errors.New("key does not exist")
+	}
+	return v, nil
+}
+
+//Generates n URL like prefixes of which roughly a third end in a wildcard. Hosts and path
+//segments are drawn from small sets so that the prefixes share long common prefixes like
+//real rule sets do.
+func randomprefixes(r *rand.Rand, n int) []string {
+	hosts := []string{"www.corpA.com", "www.corpB.com", "api.corpA.com", "www.public.org", "bücher.de"}
+	segments := []string{"admin", "pub", "doc", "en", "users", "reports", "v1", "v2", "static", "ß"}
+
+	prefixes := make([]string, n)
+	for i := range prefixes {
+		p := hosts[r.Intn(len(hosts))]
+		for s := r.Intn(6); s >= 0; s-- {
+			p += "/" + segments[r.Intn(len(segments))]
+		}
+		if r.Intn(8) == 0 {
+			p += fmt.Sprintf("/%d", r.Intn(1000))
+		}
+		switch r.Intn(3) {
+		case 0:
+			p += "/*"
+		case 1:
+			p += "*"
+		}
+		prefixes[i] = p
+	}
+	return prefixes
+}
+
+//Derives request urls from prefixes: exact matches, urls below wildcards, partial and
+//non-matching urls.
+func randomurls(r *rand.Rand, prefixes []string, n int) []string {
+	urls := make([]string, n)
+	for i := range urls {
+		p := prefixes[r.Intn(len(prefixes))]
+		if p[len(p)-1] == '*' {
+			p = p[:len(p)-1]
+		}
+		switch r.Intn(4) {
+		case 0:
+			urls[i] = p + "/x/y"
+		case 1:
+			urls[i] = p[:r.Intn(len(p)+1)]
+		case 2:
+			urls[i] = "www.unknown.net" + p[r.Intn(len(p)):]
+		default:
+			urls[i] = p
+		}
+	}
+	return urls
+}
+
+func TestMatchesArrayTree(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	prefixes := randomprefixes(r, 2000)
+
+	radix, array := New(), newArrayTree()
+	for i, p := range prefixes {
+		key := fmt.Sprintf("subject%d", i%7)
+		if err := radix.AddKey(p, key, i); err != nil {
+			t.Fatalf("adding %s failed (%s)", p, err)
+		}
+		array.AddKey(p, key, i)
+	}
+
+	for _, url := range randomurls(r, prefixes, 20000) {
+		for s := 0; s < 8; s++ {
+			key := fmt.Sprintf("subject%d", s)
+			v1, err1 := radix.Match(url, key)
+			v2, err2 := array.Match(url, key)
+			if v1 != v2 || (err1 == nil) != (err2 == nil) {
+				t.Fatalf("Match(%q, %s) = %d, %v but the array tree returns %d, %v", url, key, v1, err1, v2, err2)
+			}
+		}
+	}
+}
+
+// -----------------------------------
+// Benchmarks
+// -----------------------------------
+
+const bench_prefixes = 20000
+
+//Reports the heap memory retained by the tree build returns
+func reportmemory(b *testing.B, build func() interface{}) {
+	var before, after runtime.MemStats
+
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	tree := build()
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(tree)
+
+	b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "heap-B/tree")
+}
+
+func BenchmarkMemory(b *testing.B) {
+	prefixes := randomprefixes(rand.New(rand.NewSource(1)), bench_prefixes)
+
+	b.Run("radix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			reportmemory(b, func() interface{} {
+				tree := New()
+				for _, p := range prefixes {
+					tree.AddKey(p, "John", 1)
+				}
+				return tree
+			})
+		}
+	})
+
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			reportmemory(b, func() interface{} {
+				tree := newArrayTree()
+				for _, p := range prefixes {
+					tree.AddKey(p, "John", 1)
+				}
+				return tree
+			})
+		}
+	})
+}
+
+func BenchmarkMatch(b *testing.B) {
+	r := rand.New(rand.NewSource(1))
+	prefixes := randomprefixes(r, bench_prefixes)
+	urls := randomurls(r, prefixes, 1024)
+
+	radix, array := New(), newArrayTree()
+	for _, p := range prefixes {
+		radix.AddKey(p, "John", 1)
+		array.AddKey(p, "John", 1)
+	}
+
+	b.Run("radix", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			radix.Match(urls[i%len(urls)], "John")
+		}
+	})
+
+	b.Run("array", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			array.Match(urls[i%len(urls)], "John")
+		}
+	})
+}
@@ -0,0 +1,213 @@
+package prefixtree
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+//Version of the binary encoding of a tree
+const binaryversion = 1
+
+const (
+	flagwildcard = 1 << iota
+	flagvalue
+)
+
+//Encodes the tree into a compact binary form that UnmarshalBinary restores without inserting
+//the prefixes again. The keys of all key maps are stored once in a table at the start, the
+//nodes follow depth first, each with its flags, label, key map and number of children.
+func (t Tree) MarshalBinary() ([]byte, error) {
+	keys := make(map[string]int)
+	t.root.keys(keys)
+	table := make([]string, 0, len(keys))
+	for k := range keys {
+		table = append(table, k)
+	}
+	sort.Strings(table)
+	for i, k := range table {
+		keys[k] = i
+	}
+
+	data := []byte{binaryversion}
+	data = appenduvarint(data, uint64(len(table)))
+	for _, k := range table {
+		data = appendstring(data, k)
+	}
+	return t.root.marshal(data, keys), nil
+}
+
+//Collects the keys of the key maps of n and the nodes below it
+func (n *Node) keys(keys map[string]int) {
+	for k := range n.value {
+		keys[k] = 0
+	}
+	for _, c := range n.child {
+		c.keys(keys)
+	}
+}
+
+//Appends the encoding of n and the nodes below it to data
+func (n *Node) marshal(data []byte, keys map[string]int) []byte {
+	var flags byte
+	if n.wildcard {
+		flags |= flagwildcard
+	}
+	if n.value != nil {
+		flags |= flagvalue
+	}
+	data = append(data, flags)
+	data = appendstring(data, n.label)
+
+	if n.value != nil {
+		names := make([]string, 0, len(n.value))
+		for k := range n.value {
+			names = append(names, k)
+		}
+		sort.Strings(names)
+		data = appenduvarint(data, uint64(len(names)))
+		for _, k := range names {
+			data = appenduvarint(data, uint64(keys[k]))
+			data = appendvarint(data, int64(n.value[k]))
+		}
+	}
+
+	data = appenduvarint(data, uint64(len(n.child)))
+	for _, c := range n.child {
+		data = c.marshal(data, keys)
+	}
+	return data
+}
+
+//Replaces the tree with the one encoded in data by MarshalBinary
+func (t *Tree) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] != binaryversion {
+		return errors.New("unknown encoding of a prefix tree")
+	}
+
+	d := decoder{data: data, pos: 1}
+	table := make([]string, d.count())
+	for i := range table {
+		table[i] = d.string()
+	}
+	root := d.node(table)
+	if d.err == nil && d.pos != len(d.data) {
+		d.err = errors.New(fmt.Sprintf("%d bytes after the end of the tree", len(d.data)-d.pos))
+	}
+	if d.err != nil {
+		return errors.New(fmt.Sprintf("invalid encoding of a prefix tree (%s)", d.err))
+	}
+
+	t.root = root
+	return nil
+}
+
+//Reads the values of an encoded tree. The first error is kept in err, after it all values read
+//are zero.
+type decoder struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (d *decoder) fail(what string) {
+	if d.err == nil {
+		d.err = errors.New(fmt.Sprintf("truncated or invalid %s at byte %d", what, d.pos))
+	}
+	d.pos = len(d.data)
+}
+
+func (d *decoder) uvarint() uint64 {
+	v, n := binary.Uvarint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("number")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	v, n := binary.Varint(d.data[d.pos:])
+	if n <= 0 {
+		d.fail("number")
+		return 0
+	}
+	d.pos += n
+	return v
+}
+
+//Reads a count of items, which can't be more than the bytes left since every item takes one
+func (d *decoder) count() int {
+	v := d.uvarint()
+	if v > uint64(len(d.data)-d.pos) {
+		d.fail("count")
+		return 0
+	}
+	return int(v)
+}
+
+func (d *decoder) string() string {
+	l := d.count()
+	s := string(d.data[d.pos : d.pos+l])
+	d.pos += l
+	return s
+}
+
+func (d *decoder) node(table []string) *Node {
+	if d.pos >= len(d.data) {
+		d.fail("node")
+		return nil
+	}
+	flags := d.data[d.pos]
+	d.pos++
+
+	n := &Node{wildcard: flags&flagwildcard != 0, label: d.string()}
+	if flags&flagvalue != 0 {
+		l := d.count()
+		n.value = make(map[string]int, l)
+		for i := 0; i < l; i++ {
+			k := d.uvarint()
+			v := d.varint()
+			if k >= uint64(len(table)) {
+				d.fail("key")
+				return nil
+			}
+			n.value[table[k]] = int(v)
+		}
+	}
+
+	l := d.count()
+	if l > 0 {
+		n.index = make([]byte, 0, l)
+		n.child = make([]*Node, 0, l)
+	}
+	for i := 0; i < l; i++ {
+		c := d.node(table)
+		if d.err != nil {
+			return nil
+		}
+		if c.label == "" || (len(n.index) > 0 && c.label[0] <= n.index[len(n.index)-1]) {
+			d.fail("order of children")
+			return nil
+		}
+		n.index = append(n.index, c.label[0])
+		n.child = append(n.child, c)
+	}
+	return n
+}
+
+func appenduvarint(data []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(data, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendvarint(data []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(data, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendstring(data []byte, s string) []byte {
+	return append(appenduvarint(data, uint64(len(s))), s...)
+}
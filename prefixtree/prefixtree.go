@@ -1,12 +1,16 @@
-//Package Tree
-//TODO: Adapt the prefixtree to use a custome vocabulary so that if the custom vocabulary has less
-//characters than the ASCII set we ca use smaler child arrays and save memory.
+//Package prefixtree implements the prefix tree authz matches request URLs against.
+//
+//The tree is a byte-level radix tree: chains of nodes with a single child are compressed into
+//one edge labelled with the whole substring and each node only keeps the children it actually
+//has, sorted by the first byte of their edge. This keeps leaves and long unbranched URL paths
+//cheap compared to a trie with a fixed size child array per node.
 package prefixtree
 
 import (
 	"errors"
 	"fmt"
 	"regexp"
+	"strings"
 )
 
 // type iTree interface {
@@ -22,25 +26,81 @@ type Tree struct {
 	root *Node
 }
 
-//Each node stores an array of pointers to its childredn (child) and and array of characters
-//representing the edge connecting this node to its respective child.
+//Match is a prefix of the tree that matches a lookup as returned by MatchAll. Path is the prefix
+//without a trailing '*' and Wildcard reports whether it matched through its wildcard.
+type Match struct {
+	Path     string
+	Wildcard bool
+	Keys     map[string]int
+}
+
+//Each node stores the label of the edge leading to it from its parent, its children (child)
+//and the first byte of each child's label (index) so that a child can be found without
+//comparing whole labels. index and child are kept sorted by that byte.
+//
+//Nodes only exist where a prefix ends, where a wildcard is set or where the tree branches.
 type Node struct {
 	wildcard bool
-	child    [128]*Node
+	label    string
+	index    []byte
+	child    []*Node
 	value    map[string]int
 }
 
-func (n *Node) add(k byte) (*Node, error) {
-	// n.index_child = append(n.index_child, k)
-
-	child := new(Node)
-	// n.child = append(n.child, child)
-	if k > 127 {
-		return nil, errors.New(fmt.Sprintf("Key %s is not an ASCII character", string(k)))
+//Returns the position of the child whose label starts with k, or the position it would have
+//to be inserted at and false if there is none.
+func (n *Node) find(k byte) (int, bool) {
+	lo, hi := 0, len(n.index)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if n.index[mid] < k {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
 	}
+	return lo, lo < len(n.index) && n.index[lo] == k
+}
+
+//Adds a new child with the given label
+func (n *Node) add(label string) *Node {
+	child := &Node{label: label}
+
+	i, _ := n.find(label[0])
+	n.index = append(n.index, 0)
+	copy(n.index[i+1:], n.index[i:])
+	n.index[i] = label[0]
+	n.child = append(n.child, nil)
+	copy(n.child[i+1:], n.child[i:])
+	n.child[i] = child
 
-	n.child[k] = child
-	return child, nil
+	return child
+}
+
+//Splits the edge leading to the i-th child after l bytes of its label and returns the node
+//inserted at the split point.
+func (n *Node) split(i int, l int) *Node {
+	c := n.child[i]
+	mid := &Node{label: c.label[:l], index: []byte{c.label[l]}, child: []*Node{c}}
+	c.label = c.label[l:]
+	n.child[i] = mid
+	return mid
+}
+
+//Returns a printable label for the edge k. Bytes outside the printable ASCII range, such as
+//parts of a multi-byte UTF-8 sequence, are shown as hex escapes.
+func label(k string) string {
+	var s string
+	for i := 0; i < len(k); i++ {
+		if k[i] < 0x20 || k[i] > 0x7e {
+			s += fmt.Sprintf("\\\\x%02x", k[i])
+		} else if k[i] == '"' || k[i] == '\\' {
+			s += "\\" + string(rune(k[i]))
+		} else {
+			s += string(rune(k[i]))
+		}
+	}
+	return s
 }
 
 //Return a *string of a .dot notation of this node and all its children
@@ -49,26 +109,20 @@ func (n *Node) add(k byte) (*Node, error) {
 func (n *Node) digraph() (*string, *string) {
 	var s, wildcards string
 
-	// if len(n.index_child) == 0 {
-	// 	log.Printf("Node %p has 0 children\n", n)
-	// }
-	// log.Printf("node %p has %d childred\n", &n, len(n.index_child))
 	if n.wildcard {
 		wildcards += fmt.Sprintf(" \"%p\"", n)
 	}
-	for i, child := range n.child {
-		if child != nil {
-			s += fmt.Sprintf("  \"%p\" -> \"%p\" [ label = \"%s\" ]; \n", n, child, string(i))
-			s_tmp, wildcards_tmp := child.digraph()
-			s += *s_tmp
-			wildcards += *wildcards_tmp
-		}
+	for _, child := range n.child {
+		s += fmt.Sprintf("  \"%p\" -> \"%p\" [ label = \"%s\" ]; \n", n, child, label(child.label))
+		s_tmp, wildcards_tmp := child.digraph()
+		s += *s_tmp
+		wildcards += *wildcards_tmp
 	}
 	return &s, &wildcards
 }
 
 func (n *Node) String() string {
-	return fmt.Sprintf("Node(%d): \"%p\" -> (%d)%v", n.value, n, len(n.child), n.child)
+	return fmt.Sprintf("Node(%v): \"%p\" %q -> (%d)%v", n.value, n, n.label, len(n.child), n.child)
 }
 
 func New() *Tree {
@@ -79,11 +133,7 @@ func New() *Tree {
 
 //Add a prefix and initialize the value map. addprefix is idempotent i.e. if the prefix
 //and/or the value map exist nothing will happen the tree t will remain unchanged
-//
-//TODO: what happens when you encounter an error mid flight during insertion of a key,
-//how do you remove the part of the key that was already inserted?
 func (t Tree) addprefix(prefix string) (*Node, error) {
-	// fmt.Printf("%s, %v\n", prefix, re_star.FindString(prefix[:len(prefix)-1]) != "")
 	if len(prefix) == 0 {
 		return nil, errors.New("prefix cannot be empty")
 	} else if re_star.FindString(prefix[:len(prefix)-1]) != "" {
@@ -91,18 +141,29 @@ func (t Tree) addprefix(prefix string) (*Node, error) {
 	}
 	n := t.root
 
-	for _, p := range prefix {
-		if p == '*' {
-			n.wildcard = true
-		} else {
-			if n.child[p] == nil {
-				n.child[p], _ = n.add(byte(p))
-			}
-			n = n.child[p]
+	key := strings.TrimSuffix(prefix, "*")
+	for len(key) > 0 {
+		i, exists := n.find(key[0])
+		if !exists {
+			n = n.add(key)
+			break
 		}
 
+		c := n.child[i]
+		l := 1
+		for l < len(key) && l < len(c.label) && key[l] == c.label[l] {
+			l++
+		}
+		if l < len(c.label) {
+			c = n.split(i, l)
+		}
+		n = c
+		key = key[l:]
 	}
 
+	if strings.HasSuffix(prefix, "*") {
+		n.wildcard = true
+	}
 	if n.value == nil {
 		n.value = make(map[string]int)
 	}
@@ -110,6 +171,36 @@ func (t Tree) addprefix(prefix string) (*Node, error) {
 	return n, nil
 }
 
+//Follows prefix down the tree. found reports whether all of prefix lies on a path of the tree
+//and n is the node prefix ends at, or nil if it ends inside an edge. wildcard is the deepest
+//wildcard node passed on the way before the end of prefix and depth the length of its path.
+func (t Tree) walk(prefix string) (n *Node, found bool, wildcard *Node, depth int) {
+	n = t.root
+
+	for p := 0; p < len(prefix); {
+		if n.wildcard {
+			wildcard, depth = n, p // if a wildcard node is encountered along the path note it for checcking on later
+		}
+
+		i, exists := n.find(prefix[p])
+		if !exists {
+			return nil, false, wildcard, depth
+		}
+
+		c := n.child[i]
+		if !strings.HasPrefix(prefix[p:], c.label) {
+			if strings.HasPrefix(c.label, prefix[p:]) {
+				return nil, true, wildcard, depth
+			}
+			return nil, false, wildcard, depth
+		}
+		n = c
+		p += len(c.label)
+	}
+
+	return n, true, wildcard, depth
+}
+
 func (t Tree) SetKeys(prefix string, keys map[string]int) error {
 	n, err := t.addprefix(prefix)
 	if err != nil {
@@ -148,53 +239,148 @@ func (t Tree) AddKey(prefix string, key string, value int) error {
 
 //Only matches the prefix. On success returns the key map
 func (t Tree) MatchPrefix(prefix string) (map[string]int, error) {
-	var wildcard *Node
-	n := t.root
+	_, keys, err := t.MatchPath(prefix)
+	return keys, err
+}
 
-	// fmt.Printf("prefix: %s, key: %s\n", prefix, key)
-	for p := 0; n != nil && p < len(prefix); p++ {
-		if n.wildcard {
-			wildcard = n // if a wildcard node is encountered along the path note it for checcking on later
-		}
-		n = n.child[prefix[p]]
-		// fmt.Printf("  p: %s\n", string(prefix[p]))
-	}
+//Matches the prefix like MatchPrefix and also returns the path of the node that matched, i.e.
+//prefix itself or, for a wildcard match, the prefix the wildcard was added with minus the '*'.
+func (t Tree) MatchPath(prefix string) (string, map[string]int, error) {
+	n, found, wildcard, depth := t.walk(prefix)
 
-	if n != nil {
-		return n.value, nil
-	} else { // n == nil
+	if found {
+		if n == nil {
+			return prefix, nil, nil
+		}
+		return prefix, n.value, nil
+	} else { // prefix left the tree
 		if wildcard != nil {
-			return wildcard.value, nil
+			return prefix[:depth], wildcard.value, nil
 		} else { // wildcard == nil
-			return nil, errors.New("prefix does not match")
+			return "", nil, errors.New("prefix does not match")
 		}
 	}
+}
 
-	// return 0, nil
+//Reports whether prefix was added to the tree, with or without a trailing '*', and whether it
+//was added with one. Unlike MatchPath, which returns prefix for nodes that only lie on the path
+//to longer prefixes, it tells those apart from the prefixes that were added.
+func (t Tree) Has(prefix string) (exists bool, wildcard bool) {
+	n, found, _, _ := t.walk(strings.TrimSuffix(prefix, "*"))
+	if !found || n == nil || n.value == nil {
+		return false, false
+	}
+	return true, n.wildcard
 }
 
-func (t Tree) Match(prefix string, key string) (int, error) {
-	var wildcard *Node
+//Returns every prefix of the tree that matches prefix, from the longest to the shortest: the
+//prefix equal to prefix if there is one, followed by the wildcard prefixes passed on the way.
+//Unlike MatchPrefix, which only returns the longest match, this lets callers take the less
+//specific prefixes into account too.
+func (t Tree) MatchAll(prefix string) []Match {
+	var matches []Match
 	n := t.root
 
-	// fmt.Printf("prefix: %s, key: %s\n", prefix, key)
-	for p := 0; n != nil && p < len(prefix); p++ {
+	for p := 0; n != nil; {
+		if p == len(prefix) {
+			if n.value != nil {
+				matches = append(matches, Match{Path: prefix, Keys: n.value})
+			}
+			break
+		}
 		if n.wildcard {
-			wildcard = n // if a wildcard node is encountered along the path note it for checcking on later
+			matches = append(matches, Match{Path: prefix[:p], Wildcard: true, Keys: n.value})
+		}
+
+		i, exists := n.find(prefix[p])
+		if !exists || !strings.HasPrefix(prefix[p:], n.child[i].label) {
+			break
 		}
-		n = n.child[prefix[p]]
-		// fmt.Printf("  p: %s\n", string(prefix[p]))
+		n = n.child[i]
+		p += len(n.label)
 	}
 
-	if n != nil {
-		v, exists := n.value[key]
+	//matches were collected from the root down
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+//SkipPrefix can be returned by the function passed to Walk and WalkPrefix to skip the prefixes
+//below the one it was called for. The walk continues with the next sibling.
+var SkipPrefix = errors.New("skip the prefixes below this one")
+
+//Calls fn for every prefix of the tree with its key map, parents before their children and
+//siblings in byte order. Prefixes are passed without a trailing '*', wildcard reports whether
+//the prefix was added with one. A prefix added both with and without a wildcard is passed once,
+//the two share their key map. If fn returns SkipPrefix the prefixes below the current one are
+//skipped, if it returns any other error the walk stops and returns it.
+func (t Tree) Walk(fn func(prefix string, wildcard bool, keys map[string]int) error) error {
+	return t.root.visit("", fn)
+}
+
+//Walks the prefixes of the tree that start with prefix like Walk, e.g. all prefixes of a host
+//for "www.corpA.com/". Wildcards above prefix aren't visited, see MatchAll for those.
+func (t Tree) WalkPrefix(prefix string, fn func(prefix string, wildcard bool, keys map[string]int) error) error {
+	n, base := t.root, ""
+
+	for p := 0; p < len(prefix); {
+		i, exists := n.find(prefix[p])
+		if !exists {
+			return nil
+		}
+
+		c := n.child[i]
+		if !strings.HasPrefix(prefix[p:], c.label) {
+			if strings.HasPrefix(c.label, prefix[p:]) {
+				return c.visit(prefix[:p], fn) // prefix ends inside the edge to c
+			}
+			return nil
+		}
+		n, base = c, prefix[:p]
+		p += len(c.label)
+	}
+
+	return n.visit(base, fn)
+}
+
+//Visits n, whose parent has the path base, and the nodes below it for Walk
+func (n *Node) visit(base string, fn func(prefix string, wildcard bool, keys map[string]int) error) error {
+	path := base + n.label
+	if n.value != nil {
+		err := fn(path, n.wildcard, n.value)
+		if err == SkipPrefix {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+	for _, c := range n.child {
+		err := c.visit(path, fn)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t Tree) Match(prefix string, key string) (int, error) {
+	n, found, wildcard, _ := t.walk(prefix)
+
+	if found {
+		var v int
+		exists := false
+		if n != nil {
+			v, exists = n.value[key]
+		}
 		if exists {
 			return v, nil
 		} else {
 			// fmt.Printf("Subjects: %v\n", n.value)
 			return 0, errors.New("key does not exist")
 		}
-	} else { // n == nil
+	} else { // prefix left the tree
 		if wildcard != nil {
 			v, exists := wildcard.value[key]
 			if exists {
@@ -206,19 +392,17 @@ func (t Tree) Match(prefix string, key string) (int, error) {
 			return 0, errors.New("prefix does not match")
 		}
 	}
-
-	return 0, nil
 }
 
 func (t Tree) Get(prefix string, key string) (int, error) {
-	n := t.root
-
-	for i := 0; n != nil && i < len(prefix); i++ {
-		n = n.child[prefix[i]]
-	}
+	n, found, _, _ := t.walk(prefix)
 
-	if n != nil {
-		v, exists := n.value[key]
+	if found {
+		var v int
+		exists := false
+		if n != nil {
+			v, exists = n.value[key]
+		}
 		if exists {
 			return v, nil
 		} else {
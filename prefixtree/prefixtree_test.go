@@ -1,7 +1,9 @@
 package prefixtree
 
 import (
+	"errors"
 	"os"
+	"reflect"
 	"testing"
 )
 
@@ -44,6 +46,33 @@ func TestAddInvalidPrefix(t *testing.T) {
 	}
 }
 
+func TestAddUnicodePrefix(t *testing.T) {
+	tree := New()
+	prefix := "bücher.de/straße/*"
+
+	err := tree.AddKey(prefix, "Jürgen", 300)
+	if err != nil {
+		t.Fatalf("adding UTF-8 prefix \"%s\" failed (%s)", prefix, err)
+	}
+
+	v, err := tree.Match("bücher.de/straße/日本", "Jürgen")
+	if err != nil {
+		t.Errorf("UTF-8 url should match %s but it didn't (Error: %s)", prefix, err)
+	} else if v != 300 {
+		t.Errorf("Value of %s:%s should be %d not %d", prefix, "Jürgen", 300, v)
+	}
+
+	//'ü' and 'à' share the first byte of their UTF-8 encoding but must not match each other
+	_, err = tree.Match("bàcher.de/straße/", "Jürgen")
+	if err == nil {
+		t.Errorf("url sharing a partial UTF-8 sequence with %s should not match", prefix)
+	}
+
+	if tree.Digraph() == nil {
+		t.Errorf("Digraph of a tree with UTF-8 prefixes failed")
+	}
+}
+
 func TestSetKeys(t *testing.T) {
 	prefix := "www.corpA.com/"
 	keys := map[string]int{"John": 200, "Jim": 100}
@@ -157,6 +186,220 @@ func TestMatch(t *testing.T) {
 
 }
 
+func TestMatchPath(t *testing.T) {
+	cases := []struct {
+		url, path string
+	}{
+		{"www.corpA.com/admin", "www.corpA.com/admin"},
+		{"www.corpA.com/someresource", "www.corpA.com/"},
+		{"www.corpA.com/add", "www.corpA.com/"},
+	}
+
+	for _, c := range cases {
+		path, keys, err := tree.MatchPath(c.url)
+		if err != nil {
+			t.Errorf("%s should match but it didn't (Error: %s)", c.url, err)
+		} else if path != c.path {
+			t.Errorf("%s should match %s not %s", c.url, c.path, path)
+		} else if keys == nil {
+			t.Errorf("No key map returned for %s", c.url)
+		}
+	}
+
+	_, _, err := tree.MatchPath("www.corpC.com/")
+	if err == nil {
+		t.Errorf("www.corpC.com/ shouldn't match")
+	}
+}
+
+func TestHas(t *testing.T) {
+	tree := New()
+	tree.AddKey("www.corpA.com/*", "John", 1)
+	tree.AddKey("www.corpA.com/admin/users", "John", 2)
+
+	cases := []struct {
+		prefix           string
+		exists, wildcard bool
+	}{
+		{"www.corpA.com/", true, true},
+		{"www.corpA.com/*", true, true},
+		{"www.corpA.com/admin/users", true, false},
+		{"www.corpA.com/admin", false, false},
+		{"www.corpA.com/adm", false, false},
+		{"www.corpB.com/", false, false},
+	}
+
+	for _, c := range cases {
+		exists, wildcard := tree.Has(c.prefix)
+		if exists != c.exists || wildcard != c.wildcard {
+			t.Errorf("Has(%s) should be %t, %t not %t, %t", c.prefix, c.exists, c.wildcard, exists, wildcard)
+		}
+	}
+}
+
+func TestMatchAll(t *testing.T) {
+	tree := New()
+	tree.AddKey("www.corpA.com/*", "John", 1)
+	tree.AddKey("www.corpA.com/admin*", "John", 2)
+	tree.AddKey("www.corpA.com/admin/users", "John", 3)
+
+	cases := []struct {
+		url   string
+		paths []string
+	}{
+		{"www.corpA.com/admin/users", []string{"www.corpA.com/admin/users", "www.corpA.com/admin", "www.corpA.com/"}},
+		{"www.corpA.com/admin/groups", []string{"www.corpA.com/admin", "www.corpA.com/"}},
+		{"www.corpA.com/admin", []string{"www.corpA.com/admin", "www.corpA.com/"}},
+		{"www.corpA.com/", []string{"www.corpA.com/"}},
+		{"www.corpB.com/", nil},
+	}
+
+	for _, c := range cases {
+		matches := tree.MatchAll(c.url)
+		if len(matches) != len(c.paths) {
+			t.Errorf("%s should match %v not %v", c.url, c.paths, matches)
+			continue
+		}
+		for i, m := range matches {
+			if m.Path != c.paths[i] || m.Keys == nil {
+				t.Errorf("%s should match %v not %v", c.url, c.paths, matches)
+				break
+			}
+		}
+	}
+
+	matches := tree.MatchAll("www.corpA.com/admin")
+	if matches[0].Wildcard || !matches[1].Wildcard {
+		t.Errorf("www.corpA.com/admin should match itself exactly and www.corpA.com/ through its wildcard, not %v", matches)
+	}
+}
+
+func TestWalk(t *testing.T) {
+	tree := New()
+	tree.AddKey("www.corpA.com/admin/users", "John", 3)
+	tree.AddKey("www.corpA.com/*", "John", 1)
+	tree.AddKey("www.corpA.com/admin*", "John", 2)
+	tree.AddKey("www.corpA.com/", "Jim", 4)
+	tree.AddKey("api.corpA.com/v1", "Jim", 5)
+
+	expected := []Match{
+		{"api.corpA.com/v1", false, map[string]int{"Jim": 5}},
+		{"www.corpA.com/", true, map[string]int{"John": 1, "Jim": 4}},
+		{"www.corpA.com/admin", true, map[string]int{"John": 2}},
+		{"www.corpA.com/admin/users", false, map[string]int{"John": 3}},
+	}
+
+	var prefixes []Match
+	err := tree.Walk(func(prefix string, wildcard bool, keys map[string]int) error {
+		prefixes = append(prefixes, Match{prefix, wildcard, keys})
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("Walk visited %v instead of %v", prefixes, expected)
+	}
+
+	stop := errors.New("stop")
+	visited := 0
+	err = tree.Walk(func(prefix string, wildcard bool, keys map[string]int) error {
+		visited++
+		if prefix == "www.corpA.com/" {
+			return stop
+		}
+		return nil
+	})
+	if err != stop || visited != 2 {
+		t.Errorf("Walk returned %v after %d prefixes, expected it to stop after 2", err, visited)
+	}
+}
+
+func TestWalkPrefix(t *testing.T) {
+	tree := New()
+	tree.AddKey("www.corpA.com/*", "John", 1)
+	tree.AddKey("www.corpA.com/admin*", "John", 2)
+	tree.AddKey("www.corpA.com/admin/users", "John", 3)
+	tree.AddKey("www.corpA.com/admin/groups", "John", 4)
+	tree.AddKey("www.corpA.com/wiki", "John", 5)
+	tree.AddKey("www.corpB.com/", "John", 6)
+
+	cases := []struct {
+		prefix   string
+		prefixes []string
+	}{
+		{"www.corpA.com/", []string{"www.corpA.com/", "www.corpA.com/admin", "www.corpA.com/admin/groups", "www.corpA.com/admin/users", "www.corpA.com/wiki"}},
+		{"www.corpA.com/admin", []string{"www.corpA.com/admin", "www.corpA.com/admin/groups", "www.corpA.com/admin/users"}},
+		{"www.corpA.com/admin/", []string{"www.corpA.com/admin/groups", "www.corpA.com/admin/users"}},
+		{"www.corpA.com/ad", []string{"www.corpA.com/admin", "www.corpA.com/admin/groups", "www.corpA.com/admin/users"}},
+		{"www.corp", []string{"www.corpA.com/", "www.corpA.com/admin", "www.corpA.com/admin/groups", "www.corpA.com/admin/users", "www.corpA.com/wiki", "www.corpB.com/"}},
+		{"www.corpA.com/wiki/", nil},
+		{"www.corpC.com/", nil},
+	}
+
+	for _, c := range cases {
+		var prefixes []string
+		err := tree.WalkPrefix(c.prefix, func(prefix string, wildcard bool, keys map[string]int) error {
+			prefixes = append(prefixes, prefix)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(prefixes, c.prefixes) {
+			t.Errorf("WalkPrefix(%s) visited %v instead of %v", c.prefix, prefixes, c.prefixes)
+		}
+	}
+
+	var prefixes []string
+	tree.WalkPrefix("www.corpA.com/", func(prefix string, wildcard bool, keys map[string]int) error {
+		prefixes = append(prefixes, prefix)
+		if prefix == "www.corpA.com/admin" {
+			return SkipPrefix
+		}
+		return nil
+	})
+	expected := []string{"www.corpA.com/", "www.corpA.com/admin", "www.corpA.com/wiki"}
+	if !reflect.DeepEqual(prefixes, expected) {
+		t.Errorf("Skipping the prefixes below www.corpA.com/admin visited %v instead of %v", prefixes, expected)
+	}
+}
+
+func TestMarshalBinary(t *testing.T) {
+	tree := New()
+	tree.AddKey("www.corpA.com/*", "John", 1)
+	tree.AddKey("www.corpA.com/admin*", "John", -2)
+	tree.AddKey("www.corpA.com/admin/users", "Jim", 3)
+	tree.AddKeys("www.corpA.com/admin/groups", nil)
+	tree.SetKeys("www.corpA.com/wiki", nil)
+	tree.AddKey("api.corpA.com/v1", "Jim", 1<<40)
+
+	data, err := tree.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := New()
+	err = decoded.UnmarshalBinary(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.root, tree.root) {
+		t.Errorf("Decoding the binary encoding gave\n%s\ninstead of\n%s", *decoded.Digraph(), *tree.Digraph())
+	}
+	if v, err := decoded.Match("www.corpA.com/admin/users/1", "John"); err != nil || v != -2 {
+		t.Errorf("The decoded tree matched %d (%v) instead of -2", v, err)
+	}
+
+	for l := 0; l < len(data); l++ {
+		if err := New().UnmarshalBinary(data[:l]); err == nil {
+			t.Errorf("Decoding the first %d of %d bytes didn't fail", l, len(data))
+		}
+	}
+	if err := New().UnmarshalBinary(append(data, 0)); err == nil {
+		t.Errorf("Decoding with trailing bytes didn't fail")
+	}
+}
+
 func TestGetNonexistent(t *testing.T) {
 	var err error
 
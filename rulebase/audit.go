@@ -0,0 +1,163 @@
+package rulebase
+
+import (
+	"sort"
+)
+
+//Grantee is a subject or group with access to a URL, as returned by WhoCan
+type Grantee struct {
+	Name        string
+	Group       bool     //Name is a group
+	Access      int      //access flags the rules grant unconditionally
+	Conditional int      //access flags only conditional rules and rules with windows or expressions grant
+	Groups      []string //groups of a subject that contributed access
+	Inherited   string   //URL of the ancestor rule the access was inherited from
+}
+
+//Permission is a URL and the access a subject has on it, as returned by WhatCan
+type Permission struct {
+	Url         string
+	Access      int      //access flags the rules grant unconditionally
+	Conditional int      //access flags only conditional rules and rules with windows or expressions grant
+	Groups      []string //groups of the subject that contributed access
+	Inherited   string   //URL of the ancestor rule the access was inherited from
+}
+
+//Audience lists everybody with access to a URL, as returned by WhoCan
+type Audience struct {
+	Url      string
+	Rule     string //URL of the rule that matches Url, "" if none does
+	Default  int    //access flags of the default policy, which everybody has
+	Grantees []Grantee
+}
+
+//Returns every subject and group with access to url, sorted by name. The subjects and groups
+//of the ACL of the rule matching url, of the wildcard rules above it if the rulebase inherits
+//access and of the conditional rules and rules with windows or expressions for it are listed,
+//and groups are expanded to their members. A subject's access includes what it gets through
+//its groups. The access everybody has through the default policy is returned separately.
+func (rb Rulebase) WhoCan(url string) (*Audience, error) {
+	url, err := Canonicalize(url, false)
+	if err != nil {
+		return nil, err
+	}
+	a := Audience{Url: url}
+
+	path, key_map, err := rb.tree.MatchPath(url)
+	if err != nil {
+		if err.Error() == "prefix does not match" {
+			a.Default = rb.unmatchedflags(url)
+			return &a, nil
+		}
+		return nil, err
+	}
+	a.Default = rb.defaultflags(url)
+	rule, keys := rb.ruleacl(url, path, key_map)
+	if rule != "" {
+		a.Rule = rb.ruleurl(rule)
+	}
+
+	//the key maps that can grant access to url, the one of path first
+	maps := []map[string]int{keys}
+	for _, c := range rb.conditional[path] {
+		maps = append(maps, c.keys)
+	}
+	for _, g := range rb.timed[path] {
+		maps = append(maps, g.keys)
+	}
+	if rb.inherit {
+		for _, m := range rb.tree.MatchAll(url) {
+			if len(m.Path) < len(path) {
+				maps = append(maps, m.Keys)
+			}
+		}
+	}
+
+	members := make(map[string][]string)
+	for subject, groups := range rb.group {
+		for _, g := range groups {
+			members[g] = append(members[g], subject)
+		}
+	}
+	names := make(map[string]bool)
+	for _, keys := range maps {
+		for name := range keys {
+			names[name] = true
+			for _, m := range members[name] {
+				names[m] = true
+			}
+		}
+	}
+
+	groups := make(map[string]bool)
+	for _, g := range rb.Groups {
+		groups[g] = true
+	}
+	for name := range names {
+		g := Grantee{Name: name, Group: groups[name]}
+
+		r, _ := rb.resolve(url, path, key_map, name, nil)
+		if r.inherited != "" {
+			g.Inherited = rb.ruleurl(r.inherited)
+		}
+		g.Access = rb.flags(r.keys, name)
+		g.Groups = rb.contributing(r.keys, name)
+
+		g.Conditional = rb.conditionalflags(path, name) &^ g.Access
+
+		if g.Access|g.Conditional != 0 {
+			a.Grantees = append(a.Grantees, g)
+		}
+	}
+
+	sort.Slice(a.Grantees, func(i, j int) bool {
+		return a.Grantees[i].Name < a.Grantees[j].Name
+	})
+	return &a, nil
+}
+
+//Returns every URL of a rule subject has access to, directly or through its groups, in byte
+//order with every URL before the ones below it. The URL of a wildcard rule stands for the URLs
+//below it no other rule matches; with inheritance the URLs of rules that don't mention the
+//subject are listed too if it inherits access on them. The access everybody has through the
+//default policies isn't listed.
+func (rb Rulebase) WhatCan(subject string) []Permission {
+	var permissions []Permission
+
+	rb.tree.Walk(func(path string, wildcard bool, key_map map[string]int) error {
+		p := Permission{Url: path}
+		if wildcard {
+			p.Url = path + "*"
+		}
+
+		r, _ := rb.resolve(path, path, key_map, subject, nil)
+		if r.inherited != "" {
+			p.Inherited = r.inherited + "*"
+		} else if r.rule != "" && r.rule != path {
+			p.Inherited = r.rule + "*"
+		}
+		p.Access = rb.flags(r.keys, subject)
+		p.Groups = rb.contributing(r.keys, subject)
+		p.Conditional = rb.conditionalflags(path, subject) &^ p.Access
+
+		if p.Access|p.Conditional != 0 {
+			permissions = append(permissions, p)
+		}
+		return nil
+	})
+
+	return permissions
+}
+
+//Returns the access flags the conditional rules and rules with windows or expressions for path
+//can grant subject
+func (rb Rulebase) conditionalflags(path string, subject string) int {
+	var access_flags int
+	for _, c := range rb.conditional[path] {
+		access_flags |= rb.flags(c.keys, subject)
+	}
+	for _, t := range rb.timed[path] {
+		access_flags |= rb.flags(t.keys, subject)
+	}
+	return access_flags
+}
@@ -0,0 +1,154 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const audit_test_conf = `---
+inheritance: inherit
+default_access: [GET]
+
+groups:
+  admins: [Jim, Jane]
+  auditors: [Joe]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      John: [GET, PUT]
+      auditors: [GET]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      admins: [GET, PUT, DELETE]
+      Jane: [POST]
+  - Url: www.corpA.com/admin/*
+    Conditions:
+      Headers:
+        X-Break-Glass: "1"
+    ACL:
+      John: [GET, DELETE]`
+
+func TestWhoCan(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": audit_test_conf})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := rb.WhoCan("www.corpA.com/admin/users")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Url != "www.corpa.com/admin/users" || a.Rule != "www.corpa.com/admin/*" || a.Default != GET {
+		t.Errorf("Wrong audience %+v", a)
+	}
+
+	expected := []Grantee{
+		{"Jane", false, GET | PUT | DELETE | POST, 0, []string{"admins"}, ""},
+		{"Jim", false, GET | PUT | DELETE, 0, []string{"admins"}, ""},
+		{"Joe", false, GET, 0, []string{"auditors"}, "www.corpa.com/*"},
+		{"John", false, GET | PUT, DELETE, nil, "www.corpa.com/*"},
+		{"admins", true, GET | PUT | DELETE, 0, nil, ""},
+		{"auditors", true, GET, 0, nil, "www.corpa.com/*"},
+	}
+	if !reflect.DeepEqual(a.Grantees, expected) {
+		t.Errorf("Got grantees\n%+v\nexpected\n%+v", a.Grantees, expected)
+	}
+
+	a, err = rb.WhoCan("www.corpB.com/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.Rule != "" || a.Default != GET || len(a.Grantees) != 0 {
+		t.Errorf("Wrong audience %+v for a URL no rule matches", a)
+	}
+}
+
+func TestWhatCan(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": audit_test_conf})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := map[string][]Permission{
+		"John": {
+			{"www.corpa.com/*", GET | PUT, 0, nil, ""},
+			{"www.corpa.com/admin/*", GET | PUT, DELETE, nil, "www.corpa.com/*"},
+		},
+		"Jane": {
+			{"www.corpa.com/admin/*", GET | PUT | DELETE | POST, 0, []string{"admins"}, ""},
+		},
+		"Joe": {
+			{"www.corpa.com/*", GET, 0, []string{"auditors"}, ""},
+			{"www.corpa.com/admin/*", GET, 0, []string{"auditors"}, "www.corpa.com/*"},
+		},
+		"auditors": {
+			{"www.corpa.com/*", GET, 0, nil, ""},
+			{"www.corpa.com/admin/*", GET, 0, nil, "www.corpa.com/*"},
+		},
+		"Bob": nil,
+	}
+
+	for subject, expected := range cases {
+		permissions := rb.WhatCan(subject)
+		if !reflect.DeepEqual(permissions, expected) {
+			t.Errorf("%s got permissions\n%+v\nexpected\n%+v", subject, permissions, expected)
+		}
+	}
+}
+
+func TestInheritanceAgrees(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": audit_test_conf + `
+
+roles:
+  reader:
+    "*": [GET]
+
+role_bindings:
+  - Role: reader
+    Subjects: [Bob]
+    Scopes: [www.corpA.com/admin/reports]`})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//without a request Lookup, Diff, WhoCan and WhatCan must grant the same access
+	for _, url := range []string{"www.corpa.com/home", "www.corpa.com/admin/users", "www.corpa.com/admin/reports/q3"} {
+		a, err := rb.WhoCan(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, subject := range []string{"John", "Jane", "Joe", "Bob", "auditors", "Nobody"} {
+			access, err := rb.Lookup(subject, url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if standing := rb.standing(url, subject); standing != access {
+				t.Errorf("Diff gives %s %d on %s, Lookup %d", subject, standing, url, access)
+			}
+			audience := a.Default
+			for _, g := range a.Grantees {
+				if g.Name == subject {
+					audience |= g.Access
+				}
+			}
+			if audience != access {
+				t.Errorf("WhoCan gives %s %d on %s, Lookup %d", subject, audience, url, access)
+			}
+			permission := rb.defaultflags(url)
+			for _, p := range rb.WhatCan(subject) {
+				if strings.HasPrefix(url, strings.TrimSuffix(p.Url, "*")) {
+					permission = rb.defaultflags(url) | p.Access
+				}
+			}
+			if permission != access {
+				t.Errorf("WhatCan gives %s %d on %s, Lookup %d", subject, permission, url, access)
+			}
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+//Canonicalize returns the canonical form of a URL so that equivalent spellings of the same
+//resource always hit the same prefix in the tree. It is applied to rule URLs when they are
+//added to a rulebase and to request URLs on every lookup.
+//
+//The canonical form is host + path, where
+//  - an optional scheme such as http:// and any userinfo are removed
+//  - the host is lowercased, trailing dots and default ports (:80, :443) are stripped
+//  - internationalized host names are converted to their punycode (xn--) form
+//  - percent-escapes of unreserved characters are decoded and all other escapes are uppercased
+//  - non-ASCII bytes in the path are percent-encoded
+//  - repeated slashes are collapsed and "." and ".." segments are resolved
+//  - an empty path becomes "/"
+//  - the fragment is always dropped and the query is dropped unless keep_query is set
+func Canonicalize(url string, keep_query bool) (string, error) {
+	var query string
+
+	if i := strings.IndexByte(url, '#'); i >= 0 {
+		url = url[:i]
+	}
+	if i := strings.IndexByte(url, '?'); i >= 0 {
+		url, query = url[:i], url[i+1:]
+	}
+	if i := strings.Index(url, "://"); i >= 0 && !strings.Contains(url[:i], "/") {
+		url = url[i+3:]
+	}
+
+	host, path := url, ""
+	if i := strings.IndexByte(url, '/'); i >= 0 {
+		host, path = url[:i], url[i:]
+	}
+
+	host, err := canonicalhost(host)
+	if err != nil {
+		return "", err
+	}
+
+	path, err = unescape(path)
+	if err != nil {
+		return "", err
+	}
+	path = removedots(collapseslashes(escapenonascii(path)))
+
+	canonical := host + path
+	if keep_query && query != "" {
+		query, err = unescape(query)
+		if err != nil {
+			return "", err
+		}
+		canonical += "?" + escapenonascii(query)
+	}
+
+	return canonical, nil
+}
+
+//Returns the key of the node the rule for the canonical rule URL url is stored at in the tree.
+//"x/*" and "x/" share their node, so their rules are for the same URL.
+func rulekey(url string) string {
+	return strings.TrimSuffix(url, "*")
+}
+
+//Canonicalizes a rule URL. A trailing wildcard is preserved and, unlike request URLs, a rule
+//that ends in a wildcard directly after the host is not given a "/" path.
+func canonicalrule(url string) (string, error) {
+	if !strings.HasSuffix(url, "*") {
+		return Canonicalize(url, false)
+	}
+
+	prefix := strings.TrimSuffix(url, "*")
+	host := idnadots.Replace(prefix)
+	if i := strings.Index(host, "://"); i >= 0 {
+		host = host[i+3:]
+	}
+	//the punycode form of part of a label isn't a prefix of the punycode form of the whole label,
+	//so a wildcard within an internationalized label could never match
+	if !strings.Contains(host, "/") && !isascii(host[strings.LastIndexByte(host, '.')+1:]) {
+		return "", errors.New(fmt.Sprintf("wildcard within the internationalized host label of %s, end the rule after a whole label (\"%s.*\") or use the punycode form of the host", url, prefix))
+	}
+
+	canonical, err := Canonicalize(prefix, false)
+	if err != nil {
+		return "", err
+	}
+	if !strings.Contains(host, "/") {
+		//a trailing dot of a host wildcard ends a label, it isn't the root of a domain name
+		canonical = strings.TrimSuffix(canonical, "/")
+		if strings.HasSuffix(host, ".") {
+			canonical += "."
+		}
+	}
+
+	return canonical + "*", nil
+}
+
+//The characters IDNA maps to the dot that separates labels
+var idnadots = strings.NewReplacer("\u3002", ".", "\uff0e", ".", "\uff61", ".")
+
+//Rules carry no scheme, so both :80 and :443 are treated as default ports regardless of the
+//scheme the request came in on.
+func canonicalhost(host string) (string, error) {
+	if i := strings.LastIndexByte(host, '@'); i >= 0 {
+		host = host[i+1:]
+	}
+
+	host, err := unescape(host)
+	if err != nil {
+		return "", err
+	}
+
+	//IPv6 literals contain colons, so the port is whatever follows the closing bracket
+	name, port := strings.ToLower(host), ""
+	if i := strings.LastIndexByte(name, ':'); i >= 0 && i > strings.LastIndexByte(name, ']') {
+		name, port = name[:i], name[i+1:]
+	}
+
+	if !isascii(name) {
+		name, err = idna.Lookup.ToASCII(name)
+		if err != nil {
+			return "", errors.New(fmt.Sprintf("invalid internationalized host %q (%s)", host, err))
+		}
+	}
+	//IDNA turns full stops like "。" into dots, so trailing dots are only trimmed after it
+	name = strings.TrimRight(name, ".")
+
+	if name == "" {
+		return "", errors.New("URL has no host")
+	} else if strings.HasPrefix(name, ".") || strings.Contains(name, "..") {
+		return "", errors.New(fmt.Sprintf("empty label in host %q", host))
+	} else if strings.ContainsAny(name, "[]:") && !isipliteral(name) {
+		return "", errors.New(fmt.Sprintf("invalid host %q", host))
+	}
+	if port == "" || port == "80" || port == "443" {
+		return name, nil
+	}
+
+	return name + ":" + port, nil
+}
+
+//Reports whether host is a bracketed IPv6 literal such as [::1]
+func isipliteral(host string) bool {
+	return strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") &&
+		strings.Count(host, "[") == 1 && strings.Count(host, "]") == 1
+}
+
+func collapseslashes(path string) string {
+	for strings.Contains(path, "//") {
+		path = strings.Replace(path, "//", "/", -1)
+	}
+	return path
+}
+
+//Resolves "." and ".." segments of an absolute path as described in RFC 3986 section 5.2.4.
+//A path that ends in a dot segment keeps its trailing slash.
+func removedots(path string) string {
+	if path == "" {
+		return "/"
+	}
+
+	segments := strings.Split(path, "/")
+	out := make([]string, 0, len(segments))
+	for i, s := range segments[1:] {
+		switch s {
+		case ".":
+		case "..":
+			if len(out) > 0 {
+				out = out[:len(out)-1]
+			}
+		default:
+			out = append(out, s)
+			continue
+		}
+		if i == len(segments)-2 {
+			out = append(out, "")
+		}
+	}
+
+	return "/" + strings.Join(out, "/")
+}
+
+func isascii(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] > 0x7f {
+			return false
+		}
+	}
+	return true
+}
+
+//Percent-encodes every non-ASCII byte of s, so that a UTF-8 path written verbatim in a rule and
+//the same path sent percent-encoded by a client have the same canonical form.
+func escapenonascii(s string) string {
+	if isascii(s) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] > 0x7f {
+			b.WriteString(fmt.Sprintf("%%%02X", s[i]))
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+func isunreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
+
+func ishex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	default:
+		return c - 'A' + 10
+	}
+}
+
+//Decodes percent-escapes of unreserved characters and uppercases the hex digits of all
+//other escapes. Reserved characters such as an escaped '/' are left encoded.
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "%") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '%' {
+			b.WriteByte(s[i])
+			continue
+		}
+		if i+2 >= len(s) || !ishex(s[i+1]) || !ishex(s[i+2]) {
+			return "", errors.New(fmt.Sprintf("invalid percent-escape in %q", s))
+		}
+		c := unhex(s[i+1])<<4 | unhex(s[i+2])
+		if isunreserved(c) {
+			b.WriteByte(c)
+		} else {
+			b.WriteString(fmt.Sprintf("%%%02X", c))
+		}
+		i += 2
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,216 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//Reads the configuration file or directory filename and the files it includes. seen holds the
+//files already read, a file that is included more than once is only read the first time.
+func readconfig(filename string, seen map[string]bool) (*Config, error) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	var conf *Config
+	if info.IsDir() {
+		for _, pattern := range configpatterns {
+			matches, _ := filepath.Glob(filepath.Join(filename, pattern))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		conf = &Config{defined: make(map[string]position)}
+	} else {
+		if abs, err := filepath.Abs(filename); err == nil {
+			seen[abs] = true
+		}
+		conf, err = parseconfig(filename)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("%s: %s", filename, err))
+		}
+		for _, pattern := range conf.Include {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(filename), pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, errors.New(fmt.Sprintf("%s: invalid include %s (%s)", filename, pattern, err))
+			}
+			if len(matches) == 0 {
+				return nil, errors.New(fmt.Sprintf("%s: include %s matches no files", filename, pattern))
+			}
+			sort.Strings(matches)
+			files = append(files, matches...)
+		}
+	}
+
+	var conflicts []string
+	for _, f := range files {
+		if abs, err := filepath.Abs(f); err == nil && seen[abs] {
+			continue
+		}
+		included, err := readconfig(f, seen)
+		if err != nil {
+			return nil, err
+		}
+		conflicts = append(conflicts, conf.merge(included)...)
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return nil, errors.New(strings.Join(conflicts, "\n"))
+	}
+	return conf, nil
+}
+
+//Merges the configuration o, read from another file, into c. Rules, role bindings, default
+//policies, trusted proxies and policy tests are appended, groups, subjects and roles added.
+//Returns the definitions of o that conflict with those of c:
+//
+//	- groups, subjects and roles defined in both
+//	- settings like inheritance set to different values in both
+//	- rules without conditions, windows or expressions for the same URL that both have an ACL
+//	  entry for the same subject
+func (c *Config) merge(o *Config) []string {
+	var conflicts []string
+
+	conflict := func(key string, what string) {
+		conflicts = append(conflicts, fmt.Sprintf("%s: %s conflicts with the definition at %s", o.defined[key], what, c.defined[key]))
+	}
+	for _, key := range []string{"title", "inheritance", "default_access", "unknown_host_access"} {
+		p, exists := o.defined[key]
+		if !exists {
+			continue
+		}
+		if _, exists := c.defined[key]; exists {
+			if key != "title" && c.setting(key) != o.setting(key) {
+				conflict(key, key)
+			}
+			continue
+		}
+		switch key {
+		case "title":
+			c.Title = o.Title
+		case "inheritance":
+			c.Inheritance = o.Inheritance
+		case "default_access":
+			c.DefaultAccess = o.DefaultAccess
+		case "unknown_host_access":
+			c.UnknownHost = o.UnknownHost
+		}
+		c.defined[key] = p
+	}
+
+	for name, members := range o.Groups {
+		if _, exists := c.Groups[name]; exists {
+			conflict("groups "+name, "group "+name)
+			continue
+		}
+		if c.Groups == nil {
+			c.Groups = make(map[string][]string)
+		}
+		c.Groups[name] = members
+		c.defined["groups "+name] = o.defined["groups "+name]
+	}
+	for name, attributes := range o.Subjects {
+		if _, exists := c.Subjects[name]; exists {
+			conflict("subjects "+name, "subject "+name)
+			continue
+		}
+		if c.Subjects == nil {
+			c.Subjects = make(map[string]map[string]string)
+		}
+		c.Subjects[name] = attributes
+		c.defined["subjects "+name] = o.defined["subjects "+name]
+	}
+	for name, role := range o.Roles {
+		if _, exists := c.Roles[name]; exists {
+			conflict("roles "+name, "role "+name)
+			continue
+		}
+		if c.Roles == nil {
+			c.Roles = make(map[string]Role)
+		}
+		c.Roles[name] = role
+		c.defined["roles "+name] = o.defined["roles "+name]
+	}
+
+	c.TrustedProxies = append(c.TrustedProxies, o.TrustedProxies...)
+	c.Defaults = append(c.Defaults, o.Defaults...)
+	c.RoleBindings = append(c.RoleBindings, o.RoleBindings...)
+
+	//ACL entries of unconditional rules by URL and subject
+	entries := make(map[aclentry]position)
+	for i, r := range c.Rules {
+		for _, e := range aclentries(&r) {
+			entries[e] = c.origin(i)
+		}
+	}
+	for i, r := range o.Rules {
+		for _, e := range aclentries(&r) {
+			if p, exists := entries[e]; exists {
+				conflicts = append(conflicts, fmt.Sprintf("%s: rule for %s grants %s access already granted by the rule at %s", o.origin(i), r.Url, e.subject, p))
+			}
+		}
+	}
+	c.Rules = append(c.Rules, o.Rules...)
+	for i := range o.Rules {
+		c.origins = append(c.origins, o.origin(i))
+	}
+	c.Tests = append(c.Tests, o.Tests...)
+	for i := range o.Tests {
+		c.test_origins = append(c.test_origins, o.testorigin(i))
+	}
+
+	return conflicts
+}
+
+//Returns the position of the i-th rule, if it is known
+func (c *Config) origin(i int) position {
+	if i < len(c.origins) {
+		return c.origins[i]
+	}
+	return position{}
+}
+
+//Returns the value of the setting key as a string
+func (c *Config) setting(key string) string {
+	switch key {
+	case "inheritance":
+		return c.Inheritance
+	case "default_access":
+		return fmt.Sprint(c.DefaultAccess)
+	case "unknown_host_access":
+		return fmt.Sprint(c.UnknownHost)
+	}
+	return c.Title
+}
+
+//An ACL entry of a rule: the key of the rule's URL, see rulekey, and the subject
+type aclentry struct {
+	url, subject string
+}
+
+//Returns the ACL entries of a rule without conditions, window or expression, or none for other
+//rules and rules with an invalid URL
+func aclentries(r *Rule) []aclentry {
+	if r.Conditions != nil || r.Window != nil || r.Expression != "" {
+		return nil
+	}
+	url, err := canonicalrule(r.Url)
+	if err != nil {
+		return nil
+	}
+
+	var entries []aclentry
+	for subject := range r.ACL {
+		entries = append(entries, aclentry{rulekey(url), subject})
+	}
+	return entries
+}
@@ -0,0 +1,143 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const compose_main_conf = `---
+title: "Main configuration"
+inheritance: inherit
+
+include:
+  - teams/*.yml
+  - teams/wiki.yml
+
+groups:
+  admins: [Jim]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      admins: [GET, PUT]`
+
+const compose_base_conf = `---
+inheritance: inherit
+
+groups:
+  admins: [Jim]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      admins: [GET, PUT]`
+
+const compose_wiki_conf = `---
+groups:
+  editors: [John]
+
+rules:
+  - Url: www.corpA.com/wiki/*
+    ACL:
+      editors: [GET, PUT]`
+
+const compose_shop_conf = `---
+inheritance: inherit
+
+rules:
+  - Url: www.corpA.com/shop/*
+    ACL:
+      Jack: [GET]`
+
+func TestReadconfigInclude(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": compose_main_conf})
+	err := os.Mkdir(filepath.Join(dir, "teams"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writetestfile(t, filepath.Join(dir, "teams", "wiki.yml"), compose_wiki_conf)
+	writetestfile(t, filepath.Join(dir, "teams", "shop.yml"), compose_shop_conf)
+
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		subject, url string
+		access       int
+	}{
+		{"Jim", "www.corpA.com/index.html", GET | PUT},
+		{"Jim", "www.corpA.com/wiki/Home", GET | PUT},
+		{"John", "www.corpA.com/wiki/Home", GET | PUT},
+		{"Jack", "www.corpA.com/shop/cart", GET},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s, should be %d", access, c.subject, c.url, c.access)
+		}
+	}
+}
+
+func TestReadconfigDirectory(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"wiki.yml": compose_wiki_conf, "shop.yaml": compose_shop_conf, "README.md": "not a configuration"})
+
+	conf, err := Readconfig(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(conf.Rules) != 2 || len(conf.Groups) != 1 || conf.Inheritance != INHERIT {
+		t.Errorf("Wrong configuration %+v read from %s", conf, dir)
+	}
+}
+
+func TestReadconfigConflicts(t *testing.T) {
+	cases := []struct {
+		conf     string
+		conflict string
+	}{
+		{"rules:\n  - Url: WWW.corpA.com/*\n    ACL:\n      admins: [GET]", "b.yml:2:5: rule for WWW.corpA.com/* grants admins access already granted by the rule at "},
+		{"rules:\n  - Url: www.corpA.com/\n    ACL:\n      admins: [GET]", "b.yml:2:5: rule for www.corpA.com/ grants admins access already granted by the rule at "},
+		{"groups:\n  admins: [John]", "b.yml:2:3: group admins conflicts with the definition at "},
+		{"inheritance: override", "b.yml:1:1: inheritance conflicts with the definition at "},
+	}
+
+	for _, c := range cases {
+		dir := writetestfiles(t, map[string]string{"a.yml": compose_base_conf, "b.yml": c.conf})
+		_, err := Readconfig(dir)
+		if err == nil {
+			t.Errorf("Reading %s should fail", c.conf)
+		} else if !strings.Contains(err.Error(), c.conflict) || !strings.Contains(err.Error(), "a.yml:") {
+			t.Errorf("Wrong conflict %q for %s, should contain %q", err, c.conf, c.conflict)
+		}
+	}
+
+	//conditional rules don't conflict
+	dir := writetestfiles(t, map[string]string{"a.yml": compose_base_conf, "b.yml": "rules:\n  - Url: www.corpA.com/*\n    Conditions:\n      Query:\n        x: y\n    ACL:\n      admins: [GET]"})
+	_, err := Readconfig(dir)
+	if err != nil {
+		t.Errorf("Reading a conditional rule for the same URL should work (%s)", err)
+	}
+
+	dir = writetestfiles(t, map[string]string{"a.yml": "include: [missing/*.yml]"})
+	_, err = Readconfig(filepath.Join(dir, "a.yml"))
+	if err == nil {
+		t.Errorf("Including files that don't exist should fail")
+	}
+}
+
+func writetestfile(t *testing.T, filename string, content string) {
+	t.Helper()
+
+	err := ioutil.WriteFile(filename, []byte(content), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
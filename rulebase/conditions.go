@@ -0,0 +1,109 @@
+package rulebase
+
+import (
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//Conditions restrict a rule to requests carrying certain query parameters, header values or a
+//content type. All conditions that are set must hold for the rule to apply. A value of "*"
+//only requires the query parameter or header to be present.
+type Conditions struct {
+	Query       map[string]string `yaml:"Query,omitempty"`
+	Headers     map[string]string `yaml:"Headers,omitempty"`
+	ContentType []string          `yaml:"ContentType,omitempty"`
+}
+
+//A rule with conditions. Conditional rules are stored next to the tree, keyed by the path of
+//the prefix they were added for, and are only evaluated after the tree match. A conditional
+//rule that also has a window only applies while the window is open, one with an expression
+//only if the expression holds.
+type conditional struct {
+	url        string
+	conditions *Conditions
+	window     *window
+	expression *expression
+	captures   []string
+	keys       map[string]int
+}
+
+//Reports whether the request with the query parameters query and headers header satisfies c
+func (c *Conditions) match(query url.Values, header http.Header) bool {
+	for name, value := range c.Query {
+		values, exists := query[name]
+		if !exists || !matchvalue(value, values) {
+			return false
+		}
+	}
+
+	for name, value := range c.Headers {
+		values, exists := header[http.CanonicalHeaderKey(name)]
+		if !exists || !matchvalue(value, values) {
+			return false
+		}
+	}
+
+	if len(c.ContentType) > 0 {
+		content_type, _, err := mime.ParseMediaType(header.Get("Content-Type"))
+		if err != nil {
+			return false
+		}
+		for _, t := range c.ContentType {
+			if strings.EqualFold(t, content_type) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+func matchvalue(expected string, values []string) bool {
+	if expected == "*" {
+		return true
+	}
+	for _, v := range values {
+		if v == expected {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the key map of the first conditional rule the request satisfies, or keys if there is
+//none, and whether a conditional rule applied
+func (rb Rulebase) conditionalkeys(path string, keys map[string]int, ev *evaluation) (map[string]int, bool, error) {
+	conditionals, exists := rb.conditional[path]
+	if !exists {
+		return keys, false, nil
+	}
+
+	req := ev.req
+	query, err := url.ParseQuery(rawquery(req.Url))
+	if err != nil {
+		return nil, false, err
+	}
+
+	now := rb.now()
+	for _, c := range conditionals {
+		if (c.window == nil || c.window.contains(now)) && c.conditions.match(query, req.Header) && ev.match(c.expression, c.captures, path) {
+			return c.keys, true, nil
+		}
+	}
+
+	return keys, false, nil
+}
+
+//Returns the query string of a request URL, or an empty string if there is none
+func rawquery(raw string) string {
+	if i := strings.IndexByte(raw, '#'); i >= 0 {
+		raw = raw[:i]
+	}
+	if i := strings.IndexByte(raw, '?'); i >= 0 {
+		return raw[i+1:]
+	}
+	return ""
+}
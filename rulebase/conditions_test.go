@@ -0,0 +1,145 @@
+package rulebase
+
+import (
+	"bytes"
+	"net/http"
+	"testing"
+)
+
+const conditions_test_conf = `---
+title: "Rulebase with conditional rules"
+
+rules:
+  - Url: www.corpA.com/reports*
+    ACL:
+      Jim: [GET]
+      John: [GET]
+  - Url: www.corpA.com/reports*
+    Conditions:
+      Query:
+        export: "true"
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/reports*
+    Conditions:
+      Headers:
+        X-Tenant: acme
+      ContentType: [application/json]
+    ACL:
+      John: [GET, POST]
+  - Url: www.corpA.com/upload
+    Conditions:
+      Headers:
+        X-Upload-Token: "*"
+    ACL:
+      John: [PUT]`
+
+func TestAuthorizeConditions(t *testing.T) {
+	rb := createtestrulebase(t, conditions_test_conf)
+
+	json_header := http.Header{}
+	json_header.Set("X-Tenant", "acme")
+	json_header.Set("Content-Type", "application/json; charset=utf-8")
+	token_header := http.Header{}
+	token_header.Set("X-Upload-Token", "abc")
+
+	cases := []struct {
+		subject, url string
+		header       http.Header
+		access       int
+	}{
+		{"Jim", "www.corpA.com/reports", nil, GET},
+		{"John", "www.corpA.com/reports", nil, GET},
+		{"Jim", "www.corpA.com/reports?export=true", nil, GET},
+		{"John", "www.corpA.com/reports?export=true", nil, 0},
+		{"John", "www.corpA.com/reports/2020?%65xport=true", nil, 0},
+		{"John", "www.corpA.com/reports?export=false", nil, GET},
+		{"John", "www.corpA.com/reports", json_header, GET | POST},
+		{"Jim", "www.corpA.com/reports", json_header, 0},
+		{"John", "www.corpA.com/upload", nil, 0},
+		{"John", "www.corpA.com/upload", token_header, PUT},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Authorize(&Request{Subject: c.subject, Url: c.url, Header: c.header})
+		if err != nil {
+			t.Errorf("Authorize %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s (header %v), should be %d", access, c.subject, c.url, c.header, c.access)
+		}
+	}
+}
+
+func TestConditionsContentType(t *testing.T) {
+	c := Conditions{ContentType: []string{"application/json"}}
+
+	header := http.Header{}
+	if c.match(nil, header) {
+		t.Errorf("Request without Content-Type shouldn't satisfy %v", c)
+	}
+	header.Set("Content-Type", "Application/JSON")
+	if !c.match(nil, header) {
+		t.Errorf("Content-Type %s should satisfy %v", header.Get("Content-Type"), c)
+	}
+	header.Set("Content-Type", "text/plain")
+	if c.match(nil, header) {
+		t.Errorf("Content-Type %s shouldn't satisfy %v", header.Get("Content-Type"), c)
+	}
+}
+
+//A conditional rule or a rule with a window for a URL without an unconditional rule must not
+//hide the ACL of the wildcard rule above it from other requests
+func TestConditionsKeepAncestorACL(t *testing.T) {
+	rb := createtestrulebase(t, `---
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      Jim: [GET]
+      John: [GET]
+  - Url: www.corpA.com/admin*
+    Conditions:
+      Headers:
+        X-Break-Glass: "1"
+    ACL:
+      John: [GET, DELETE]
+  - Url: www.corpA.com/wiki*
+    Window:
+      NotAfter: 2000-01-01
+    ACL:
+      Jack: [PUT]`)
+
+	var buf bytes.Buffer
+	err := rb.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := ReadSnapshot(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	break_glass := http.Header{"X-Break-Glass": {"1"}}
+	cases := []struct {
+		subject, url string
+		header       http.Header
+		access       int
+		rule         string
+	}{
+		{"Jim", "www.corpA.com/admin/users", nil, GET, "www.corpa.com/*"},
+		{"Jim", "www.corpA.com/admin", nil, GET, "www.corpa.com/*"},
+		{"John", "www.corpA.com/admin/users", nil, GET, "www.corpa.com/*"},
+		{"John", "www.corpA.com/admin/users", break_glass, GET | DELETE, "www.corpa.com/admin*"},
+		{"Jim", "www.corpA.com/admin/users", break_glass, 0, "www.corpa.com/admin*"},
+		{"Jim", "www.corpA.com/wiki/home", nil, GET, "www.corpa.com/*"},
+	}
+	for name, rb := range map[string]*Rulebase{"rulebase": rb, "snapshot": snapshot} {
+		for _, c := range cases {
+			d, err := rb.Explain(&Request{Subject: c.subject, Method: "GET", Url: c.url, Header: c.header})
+			if err != nil {
+				t.Errorf("%s: Explain %s:%s failed (%s)", name, c.subject, c.url, err)
+			} else if d.Access != c.access || d.Rule != c.rule {
+				t.Errorf("%s: %s:%s (header %v) gets %d from rule %q, should get %d from %q", name, c.subject, c.url, c.header, d.Access, d.Rule, c.access, c.rule)
+			}
+		}
+	}
+}
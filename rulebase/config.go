@@ -0,0 +1,158 @@
+package rulebase
+
+import (
+	"fmt"
+	"io/ioutil"
+)
+
+//Config mirrors the layout of authz's YAML configuration file. Include lists glob patterns of
+//further configuration files, relative to the including file, whose contents are merged into
+//the configuration.
+type Config struct {
+	Title          string                       `yaml:"title,omitempty"`
+	TrustedProxies []string                     `yaml:"trusted_proxies,omitempty"`
+	Inheritance    string                       `yaml:"inheritance,omitempty"`
+	DefaultAccess  []string                     `yaml:"default_access,omitempty"`
+	Defaults       []DefaultPolicy              `yaml:"defaults,omitempty"`
+	UnknownHost    Access                       `yaml:"unknown_host_access,omitempty"`
+	Groups         map[string][]string          `yaml:"groups,omitempty"`
+	Subjects       map[string]map[string]string `yaml:"subjects,omitempty"`
+	Roles          map[string]Role              `yaml:"roles,omitempty"`
+	RoleBindings   []RoleBinding                `yaml:"role_bindings,omitempty"`
+	Rules          []Rule                       `yaml:"rules,omitempty"`
+	Include        []string                     `yaml:"include,omitempty"`
+	Tests          []PolicyTest                 `yaml:"tests,omitempty"`
+
+	//where the rules, tests, groups, subjects, roles and settings were defined
+	origins      []position
+	test_origins []position
+	defined      map[string]position
+}
+
+//Access is a list of HTTP verbs for a setting where an empty list and no list differ. It is
+//left out of an encoded configuration only if it is nil.
+type Access []string
+
+func (a Access) IsZero() bool {
+	return a == nil
+}
+
+//A position in a configuration file
+type position struct {
+	file         string
+	line, column int
+}
+
+func (p position) String() string {
+	return fmt.Sprintf("%s:%d:%d", p.file, p.line, p.column)
+}
+
+//Reads and parses the configuration file filename. If filename is a directory every .yml,
+//.yaml, .json and .toml file in it is read. The files a configuration includes are read as well
+//and merged into it (see merge).
+func Readconfig(filename string) (*Config, error) {
+	return readconfig(filename, make(map[string]bool))
+}
+
+//Parses the single configuration file filename and records where its rules, tests, groups,
+//subjects, roles and settings are defined
+func parseconfig(filename string) (*Config, error) {
+	var conf Config
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := parsedocument(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	conf.defined = make(map[string]position)
+	if len(root.Content) == 0 {
+		return &conf, nil
+	}
+	err = root.Decode(&conf)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := root.Content[0]
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		switch key.Value {
+		case "rules":
+			for _, r := range value.Content {
+				conf.origins = append(conf.origins, position{filename, r.Line, r.Column})
+			}
+		case "tests":
+			for _, t := range value.Content {
+				conf.test_origins = append(conf.test_origins, position{filename, t.Line, t.Column})
+			}
+		case "groups", "subjects", "roles":
+			for j := 0; j+1 < len(value.Content); j += 2 {
+				name := value.Content[j]
+				conf.defined[key.Value+" "+name.Value] = position{filename, name.Line, name.Column}
+			}
+		default:
+			conf.defined[key.Value] = position{filename, key.Line, key.Column}
+		}
+	}
+
+	return &conf, nil
+}
+
+//Reads the configuration file filename and creates a rulebase with its rules, roles, groups and
+//subject attributes. filename can also be a snapshot written by WriteSnapshot.
+func Load(filename string) (*Rulebase, error) {
+	if issnapshotfile(filename) {
+		return LoadSnapshot(filename)
+	}
+
+	conf, err := Readconfig(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	return Build(conf)
+}
+
+//Creates a rulebase from a configuration
+func Build(conf *Config) (*Rulebase, error) {
+	rb, err := Create(&conf.Rules)
+	if err != nil {
+		return nil, err
+	}
+	err = rb.AddRoles(conf.Roles, conf.RoleBindings)
+	if err != nil {
+		return nil, err
+	}
+	rb.AddGroups(conf.Groups)
+	for subject, attributes := range conf.Subjects {
+		rb.SetAttributes(subject, attributes)
+	}
+	err = rb.SetTrustedProxies(conf.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	err = rb.SetInheritance(conf.Inheritance)
+	if err != nil {
+		return nil, err
+	}
+	err = rb.SetDefaultAccess(conf.DefaultAccess)
+	if err != nil {
+		return nil, err
+	}
+	err = rb.AddDefaultPolicies(conf.Defaults)
+	if err != nil {
+		return nil, err
+	}
+	if conf.UnknownHost != nil {
+		err = rb.SetUnknownHostAccess(conf.UnknownHost)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return rb, nil
+}
@@ -0,0 +1,85 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//DefaultPolicy sets the access everybody has on a URL, on top of what its rules grant. Like a
+//rule's URL it can end in a wildcard, so www.site.com/* sets the default policy of a whole host.
+type DefaultPolicy struct {
+	Url    string   `yaml:"Url"`
+	Access []string `yaml:"Access"`
+}
+
+//Sets the default access for URLs matching url. The most specific default policy matching a
+//request URL replaces the global one set with SetDefaultAccess.
+func (rb *Rulebase) AddDefaultAccess(url string, access []string) error {
+	access_flags, err := accessflags(access)
+	if err != nil {
+		return err
+	}
+	url, err = canonicalrule(url)
+	if err != nil {
+		return err
+	}
+
+	return rb.defaults.AddKey(url, "", access_flags)
+}
+
+//Sets the access for requests to hosts the rulebase has neither rules nor default policies for.
+//Without it such requests get the global default access.
+func (rb *Rulebase) SetUnknownHostAccess(access []string) error {
+	access_flags, err := accessflags(access)
+	if err != nil {
+		return err
+	}
+
+	rb.unknown_host_flags = &access_flags
+	return nil
+}
+
+//Returns the default access flags for the canonical URL url
+func (rb Rulebase) defaultflags(url string) int {
+	for _, m := range rb.defaults.MatchAll(url) {
+		if flags, exists := m.Keys[""]; exists {
+			return flags
+		}
+	}
+	return rb.default_access_flags
+}
+
+//Returns the access flags for a URL none of the rules match: those of the unknown host policy if
+//the host of url isn't configured at all, the default access flags otherwise
+func (rb Rulebase) unmatchedflags(url string) int {
+	if rb.unknown_host_flags != nil && !rb.configured(url) {
+		return *rb.unknown_host_flags
+	}
+	return rb.defaultflags(url)
+}
+
+//Reports whether there is a rule or a default policy for the host of the canonical URL url
+func (rb Rulebase) configured(url string) bool {
+	host := url
+	if i := strings.IndexByte(url, '/'); i >= 0 {
+		host = url[:i]
+	}
+
+	if _, _, err := rb.tree.MatchPath(host + "/"); err == nil {
+		return true
+	}
+	_, _, err := rb.defaults.MatchPath(host + "/")
+	return err == nil
+}
+
+//Adds the default policies of a configuration
+func (rb *Rulebase) AddDefaultPolicies(policies []DefaultPolicy) error {
+	for _, p := range policies {
+		err := rb.AddDefaultAccess(p.Url, p.Access)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid default policy for %s (%s)", p.Url, err))
+		}
+	}
+	return nil
+}
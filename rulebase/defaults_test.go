@@ -0,0 +1,85 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const defaults_test_conf = `---
+title: "Rulebase with default policies"
+
+default_access: [GET]
+unknown_host_access: []
+
+defaults:
+  - Url: www.public.org/*
+    Access: [GET, POST]
+  - Url: www.corpA.com/*
+    Access: []
+  - Url: www.corpA.com/pub/*
+    Access: [GET]
+
+rules:
+  - Url: www.corpA.com/admin/*
+    ACL:
+      Jim: [PUT]
+  - Url: www.corpB.com/*
+    ACL:
+      Jim: [PUT]`
+
+func TestDefaultPolicies(t *testing.T) {
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
+	err := ioutil.WriteFile(config_filename, []byte(defaults_test_conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := Load(config_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		subject, url string
+		access       int
+	}{
+		{"Jim", "www.public.org/index.html", GET | POST},
+		{"Jim", "www.corpA.com/admin/users", PUT},
+		{"Jim", "www.corpA.com/index.html", 0},
+		{"Jim", "www.corpA.com/pub/paper.pdf", GET},
+		{"Jack", "www.corpA.com/admin/users", 0},
+		//hosts without a default policy get the global one
+		{"Jim", "www.corpB.com/index.html", PUT | GET},
+		{"Jack", "www.corpB.com/index.html", GET},
+		//hosts that aren't configured at all get the unknown host policy
+		{"Jim", "www.corpC.com/index.html", 0},
+		{"Jim", "www.corpA.co/index.html", 0},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s, should be %d", access, c.subject, c.url, c.access)
+		}
+	}
+}
+
+func TestSetDefaultAccess(t *testing.T) {
+	rb := New()
+	err := rb.SetDefaultAccess([]string{"GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//without an unknown host policy unconfigured hosts get the default access
+	access, err := rb.Lookup("Jim", "www.corpA.com/index.html")
+	if err != nil || access != GET {
+		t.Errorf("Wrong authorization value %d (%v) for the default policy, should be %d", access, err, GET)
+	}
+
+	if rb.AddDefaultAccess("www.corpA.com/*", []string{"READ"}) == nil {
+		t.Errorf("Adding a default policy with an unknown verb should fail")
+	}
+}
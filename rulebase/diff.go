@@ -0,0 +1,146 @@
+package rulebase
+
+import (
+	"sort"
+	"strings"
+)
+
+//Change is a change of the access a subject or group has on a URL between two configurations,
+//as returned by Diff. Url is the canonical URL of a rule, role scope or default policy of
+//either configuration; a wildcard URL stands for the URLs below it no other rule matches.
+type Change struct {
+	Subject string
+	Group   bool //Subject is the name of a group in either configuration
+	Url     string
+	Added   int //access flags the new configuration grants and the old one doesn't
+	Removed int //access flags the old configuration grants and the new one doesn't
+}
+
+//Compares the access two configurations grant and returns the changes, sorted by subject and
+//URL. Every group, group member, declared subject and subject of an ACL entry or role binding
+//of either configuration is compared at every URL of either configuration, so a change of a
+//group's members or of a wildcard rule shows up for every subject and URL it affects.
+//
+//The access compared is the one a request gets without any conditions: unconditional rules and
+//roles, combined with the groups of the subject, inherited from wildcard rules with
+//inheritance and completed with the default policies. Conditional rules, windows, expressions
+//and network restrictions depend on the request and aren't taken into account.
+func Diff(old_conf *Config, new_conf *Config) ([]Change, error) {
+	var changes []Change
+
+	old_rb, err := Build(old_conf)
+	if err != nil {
+		return nil, err
+	}
+	new_rb, err := Build(new_conf)
+	if err != nil {
+		return nil, err
+	}
+
+	urls := make(map[string]bool)
+	subjects := make(map[string]bool)
+	groups := make(map[string]bool)
+	for _, c := range []*Config{old_conf, new_conf} {
+		for _, url := range c.urls() {
+			urls[url] = true
+		}
+		for _, s := range c.names() {
+			subjects[s] = true
+		}
+		for g := range c.Groups {
+			groups[g] = true
+		}
+	}
+
+	for subject := range subjects {
+		for url := range urls {
+			//a wildcard URL is compared below its prefix, where only wildcard rules apply, so a
+			//wildcard rule that becomes an exact one or the other way round shows up
+			path := url
+			if strings.HasSuffix(url, "*") {
+				path = strings.TrimSuffix(url, "*") + below
+			}
+			old_flags := old_rb.standing(path, subject)
+			new_flags := new_rb.standing(path, subject)
+			if old_flags != new_flags {
+				changes = append(changes, Change{subject, groups[subject], url, new_flags &^ old_flags, old_flags &^ new_flags})
+			}
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Subject != changes[j].Subject {
+			return changes[i].Subject < changes[j].Subject
+		}
+		return changes[i].Url < changes[j].Url
+	})
+	return changes, nil
+}
+
+//Appended to the prefix of a wildcard URL to get a URL below it that no other rule matches. It
+//never occurs in canonical URLs, so it leaves the tree at the end of the prefix.
+const below = "\x00"
+
+//Returns the access subject has on the canonical URL url without taking the request into
+//account, see Diff
+func (rb Rulebase) standing(url string, subject string) int {
+	path, key_map, err := rb.tree.MatchPath(url)
+	if err != nil {
+		return rb.unmatchedflags(url)
+	}
+	//without a request to evaluate resolve doesn't fail
+	a, _ := rb.resolve(url, path, key_map, subject, nil)
+
+	return rb.flags(a.keys, subject) | rb.defaultflags(url)
+}
+
+//Returns the canonical URLs of the rules, role scopes and default policies of the configuration.
+//URLs that can't be canonicalized are left out, building the configuration fails for them.
+func (c *Config) urls() []string {
+	var urls []string
+
+	add := func(url string) {
+		if canonical, err := canonicalrule(url); err == nil {
+			urls = append(urls, canonical)
+		}
+	}
+	for _, r := range c.Rules {
+		add(r.Url)
+	}
+	for _, b := range c.RoleBindings {
+		for _, scope := range b.Scopes {
+			for path := range c.Roles[b.Role] {
+				add(scopeurl(scope, path))
+			}
+		}
+	}
+	for _, p := range c.Defaults {
+		add(p.Url)
+	}
+
+	return urls
+}
+
+//Returns the names of the groups, group members, declared subjects and subjects of ACL entries
+//and role bindings of the configuration
+func (c *Config) names() []string {
+	var names []string
+
+	for g, members := range c.Groups {
+		names = append(names, g)
+		names = append(names, members...)
+	}
+	for s := range c.Subjects {
+		names = append(names, s)
+	}
+	for _, r := range c.Rules {
+		for s := range r.ACL {
+			names = append(names, s)
+		}
+	}
+	for _, b := range c.RoleBindings {
+		names = append(names, b.Subjects...)
+	}
+
+	return names
+}
@@ -0,0 +1,128 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+const diff_old_conf = `---
+inheritance: inherit
+
+groups:
+  admins: [Jim]
+  developers: [John, Jane]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      developers: [GET]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      admins: [GET, PUT]
+  - Url: www.corpA.com/admin/reports
+    ACL:
+      auditors: [GET]
+  - Url: www.corpA.com/beta
+    Conditions:
+      Headers:
+        X-Beta: "1"
+    ACL:
+      developers: [GET, PUT]`
+
+const diff_new_conf = `---
+inheritance: inherit
+
+groups:
+  admins: [Jim, Jane]
+  developers: [John, Jane]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      developers: [GET, POST]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      admins: [GET, PUT]
+  - Url: www.corpA.com/admin/reports
+    ACL:
+      auditors: [GET]
+      developers: []
+  - Url: www.corpA.com/beta
+    Conditions:
+      Headers:
+        X-Beta: "1"
+    ACL:
+      developers: [GET, PUT, DELETE]`
+
+func TestDiff(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"old.yml": diff_old_conf, "new.yml": diff_new_conf})
+	old_conf, err := Readconfig(filepath.Join(dir, "old.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new_conf, err := Readconfig(filepath.Join(dir, "new.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []Change{
+		{"Jane", false, "www.corpa.com/*", POST, 0},
+		{"Jane", false, "www.corpa.com/admin/*", PUT, 0},
+		{"Jane", false, "www.corpa.com/admin/reports", 0, GET},
+		{"Jane", false, "www.corpa.com/beta", POST, 0},
+		{"John", false, "www.corpa.com/*", POST, 0},
+		{"John", false, "www.corpa.com/admin/*", POST, 0},
+		{"John", false, "www.corpa.com/admin/reports", 0, GET},
+		{"John", false, "www.corpa.com/beta", POST, 0},
+		{"developers", true, "www.corpa.com/*", POST, 0},
+		{"developers", true, "www.corpa.com/admin/*", POST, 0},
+		{"developers", true, "www.corpa.com/admin/reports", 0, GET},
+		{"developers", true, "www.corpa.com/beta", POST, 0},
+	}
+
+	changes, err := Diff(old_conf, new_conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, c := range changes {
+		if i >= len(expected) || c != expected[i] {
+			t.Errorf("Unexpected change %+v", c)
+		}
+	}
+	if len(changes) != len(expected) {
+		t.Errorf("Got %d changes, expected %d", len(changes), len(expected))
+	}
+
+	changes, err = Diff(old_conf, old_conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(changes) != 0 {
+		t.Errorf("Comparing a configuration with itself returned changes %+v", changes)
+	}
+}
+
+//A wildcard rule that becomes an exact one keeps the access on its prefix but takes it away below
+func TestDiffWildcardToExact(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{
+		"old.yml": "rules:\n  - Url: www.a.com/pub*\n    ACL:\n      Jim: [GET]\n",
+		"new.yml": "rules:\n  - Url: www.a.com/pub\n    ACL:\n      Jim: [GET]\n",
+	})
+	old_conf, err := Readconfig(filepath.Join(dir, "old.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	new_conf, err := Readconfig(filepath.Join(dir, "new.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Diff(old_conf, new_conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Change{"Jim", false, "www.a.com/pub*", 0, GET}
+	if len(changes) != 1 || changes[0] != expected {
+		t.Errorf("Changes should be %+v not %+v", expected, changes)
+	}
+}
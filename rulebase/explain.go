@@ -0,0 +1,84 @@
+package rulebase
+
+import (
+	"sort"
+	"strings"
+)
+
+//Decision explains how the access flags of a request came about, as returned by Explain
+type Decision struct {
+	Access  int  //the access flags of the request
+	Allowed bool //whether Access includes the request method
+	Url     string
+
+	Rule        string   //URL of the rule whose ACL applied, "" if no rule matched
+	Conditional bool     //the ACL of a conditional rule applied
+	Inherited   string   //URL of the ancestor rule the access was inherited from
+	Groups      []string //groups of the subject that contributed access
+	Grants      []string //URLs of rules with windows or expressions that added access
+	Default     int      //access flags of the default policy
+	UnknownHost bool     //the request is for a host the rulebase has no rules for
+	Unreachable bool     //the client is outside the networks the URL is restricted to
+}
+
+//Looks up a request like Authorize and explains the result. The request method decides whether
+//the request is allowed.
+func (rb Rulebase) Explain(req *Request) (*Decision, error) {
+	var d Decision
+
+	method, err := accessflags([]string{strings.ToUpper(req.Method)})
+	if err != nil {
+		return nil, err
+	}
+
+	d.Access, err = rb.authorize(req, &d)
+	if err != nil {
+		return nil, err
+	}
+	d.Allowed = d.Access&method != 0
+
+	return &d, nil
+}
+
+//Returns the names of the verbs in access_flags
+func Verbs(access_flags int) []string {
+	verbs := []string{}
+	for _, v := range []struct {
+		name string
+		flag int
+	}{{"GET", GET}, {"PUT", PUT}, {"POST", POST}, {"DELETE", DELETE}, {"UPDATE", UPDATE}} {
+		if access_flags&v.flag != 0 {
+			verbs = append(verbs, v.name)
+		}
+	}
+	return verbs
+}
+
+//Converts the names of HTTP verbs, like those returned by Verbs, to access flags
+func AccessFlags(verbs []string) (int, error) {
+	return accessflags(verbs)
+}
+
+//Returns the URL of the rule stored at path in the tree, with a trailing '*' if it is a wildcard
+//rule, or "" if path only lies on the way to other rules
+func (rb Rulebase) ruleurl(path string) string {
+	exists, wildcard := rb.tree.Has(path)
+	if !exists {
+		return ""
+	} else if wildcard {
+		return path + "*"
+	}
+	return path
+}
+
+//Returns the groups of subject that have an entry with access flags in key_map, sorted
+func (rb Rulebase) contributing(key_map map[string]int, subject string) []string {
+	var groups []string
+	for _, g := range rb.group[subject] {
+		if key_map[g] != 0 {
+			groups = append(groups, g)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
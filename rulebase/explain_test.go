@@ -0,0 +1,69 @@
+package rulebase
+
+import (
+	"reflect"
+	"testing"
+)
+
+const explain_test_conf = `---
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      admins: [GET, POST]
+      developers: [GET]
+      John: [GET]
+  - Url: www.corpA.com/admin
+    ACL:
+      John: []
+  - Url: www.corpA.com/reports*
+    Conditions:
+      Query:
+        export: "*"
+    ACL:
+      John: [GET]
+  - Url: www.corpA.com/*
+    Expression: request.method == "PUT"
+    ACL:
+      Jack: [PUT]`
+
+func TestExplain(t *testing.T) {
+	rb := createtestrulebase(t, explain_test_conf)
+	rb.AddGroup("admins", []string{"Jim"})
+	rb.AddGroup("developers", []string{"Jim"})
+	rb.SetDefaultAccess([]string{"UPDATE"})
+
+	cases := []struct {
+		req      Request
+		decision Decision
+	}{
+		{Request{Subject: "Jim", Method: "POST", Url: "www.corpA.com/index.html"},
+			Decision{Access: GET | POST | UPDATE, Allowed: true, Url: "www.corpa.com/index.html", Rule: "www.corpa.com/*", Groups: []string{"admins", "developers"}, Default: UPDATE}},
+		{Request{Subject: "John", Method: "get", Url: "www.corpA.com/admin"},
+			Decision{Access: UPDATE, Url: "www.corpa.com/admin", Rule: "www.corpa.com/admin", Default: UPDATE}},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpA.com/reports?export=csv"},
+			Decision{Access: GET | UPDATE, Allowed: true, Url: "www.corpa.com/reports", Rule: "www.corpa.com/reports*", Conditional: true, Default: UPDATE}},
+		{Request{Subject: "Jack", Method: "PUT", Url: "www.corpA.com/index.html"},
+			Decision{Access: PUT | UPDATE, Allowed: true, Url: "www.corpa.com/index.html", Rule: "www.corpa.com/*", Grants: []string{"www.corpa.com/*"}, Default: UPDATE}},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpA.com/"},
+			Decision{Access: GET | UPDATE, Allowed: true, Url: "www.corpa.com/", Rule: "www.corpa.com/*", Default: UPDATE}},
+		//www.corpa.com/adm only lies on the way to www.corpa.com/admin, no rule exists for it
+		{Request{Subject: "John", Method: "GET", Url: "www.corpA.com/adm"},
+			Decision{Access: UPDATE, Url: "www.corpa.com/adm", Default: UPDATE}},
+		{Request{Subject: "Jim", Method: "GET", Url: "www.corpB.com/"},
+			Decision{Access: UPDATE, Url: "www.corpb.com/", Default: UPDATE}},
+	}
+
+	for _, c := range cases {
+		d, err := rb.Explain(&c.req)
+		if err != nil {
+			t.Errorf("Explain %+v failed (%s)", c.req, err)
+		} else if !reflect.DeepEqual(*d, c.decision) {
+			t.Errorf("Wrong decision %+v for %+v, should be %+v", *d, c.req, c.decision)
+		}
+	}
+
+	_, err := rb.Explain(&Request{Subject: "Jim", Method: "FETCH", Url: "www.corpA.com/"})
+	if err == nil {
+		t.Errorf("Explaining a request with an unknown method should fail")
+	}
+}
@@ -0,0 +1,218 @@
+package rulebase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//Returns a configuration that builds a rulebase equivalent to rb, in canonical form: rule URLs
+//are canonicalized and sorted, access is given as lists of verbs in a fixed order, group
+//members are sorted and a URL's rules come in the order its ACL, network restrictions,
+//conditional rules and rules with windows or expressions are evaluated in. Role bindings are
+//exported as the ACL entries they were expanded into, and rules for a URL with and without a
+//wildcard as one wildcard rule, since the two share their ACL.
+func (rb Rulebase) Export() *Config {
+	conf := Config{}
+
+	if rb.inherit {
+		conf.Inheritance = INHERIT
+	}
+	if rb.default_access_flags != 0 {
+		conf.DefaultAccess = Verbs(rb.default_access_flags)
+	}
+	if rb.unknown_host_flags != nil {
+		conf.UnknownHost = Verbs(*rb.unknown_host_flags)
+	}
+	conf.TrustedProxies = cidrs(rb.trusted)
+
+	rb.defaults.Walk(func(path string, wildcard bool, keys map[string]int) error {
+		if flags, exists := keys[""]; exists {
+			conf.Defaults = append(conf.Defaults, DefaultPolicy{Url: wildcardurl(path, wildcard), Access: Verbs(flags)})
+		}
+		return nil
+	})
+
+	if len(rb.Groups) > 0 {
+		conf.Groups = make(map[string][]string)
+		for _, g := range rb.Groups {
+			conf.Groups[g] = []string{}
+		}
+		for subject, groups := range rb.group {
+			for _, g := range groups {
+				conf.Groups[g] = append(conf.Groups[g], subject)
+			}
+		}
+		for g := range conf.Groups {
+			sort.Strings(conf.Groups[g])
+		}
+	}
+	if len(rb.attributes) > 0 {
+		conf.Subjects = make(map[string]map[string]string)
+		for subject, attributes := range rb.attributes {
+			conf.Subjects[subject] = attributes
+		}
+	}
+
+	//a rule with an empty ACL still hides the ACL of the wildcard rules above it, only the nodes
+	//of conditional rules and rules with windows or expressions are left to those rules. Nodes
+	//with role entries become rules that grant them on top of the ACL they inherit.
+	rules := make(map[string][]Rule)
+	rb.tree.Walk(func(path string, wildcard bool, keys map[string]int) error {
+		if keys == nil || rb.placeholders[path] && len(keys) == 0 {
+			return nil
+		}
+		_, keys = rb.ruleacl(path, path, keys)
+		rules[path] = append(rules[path], Rule{Url: wildcardurl(path, wildcard), ACL: acl(keys)})
+		return nil
+	})
+	rb.exportrules(rules)
+
+	paths := make([]string, 0, len(rules))
+	for path := range rules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		conf.Rules = append(conf.Rules, rules[path]...)
+	}
+
+	return &conf
+}
+
+//Encodes the configuration in format, yaml, json or toml. JSON and TOML are encoded with the
+//keys of the YAML format, so all of them can be read by Readconfig.
+func (c *Config) Encode(format string) ([]byte, error) {
+	var doc yaml.Node
+	var buf bytes.Buffer
+
+	err := doc.Encode(c)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case "yaml", "yml":
+		flowlists(&doc)
+		buf.WriteString("---\n")
+		e := yaml.NewEncoder(&buf)
+		e.SetIndent(2)
+		err = e.Encode(&doc)
+		if err == nil {
+			err = e.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case "json":
+		var v interface{}
+		err = doc.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(data, '\n'), nil
+	case "toml":
+		var v map[string]interface{}
+		err = doc.Decode(&v)
+		if err != nil {
+			return nil, err
+		}
+		e := toml.NewEncoder(&buf)
+		e.Indent = ""
+		err = e.Encode(v)
+		if err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	return nil, errors.New(fmt.Sprintf("Unknown configuration format %s", format))
+}
+
+//Sets the lists of single values below n, like lists of verbs, to the flow style: [GET, PUT]
+func flowlists(n *yaml.Node) {
+	flow := n.Kind == yaml.SequenceNode
+	for _, c := range n.Content {
+		flowlists(c)
+		flow = flow && c.Kind == yaml.ScalarNode
+	}
+	if flow {
+		n.Style = yaml.FlowStyle
+	}
+}
+
+//Adds the network restrictions, conditional rules and rules with windows or expressions of rb,
+//which aren't stored in the tree, to rules by path. The rules of a path keep the order they
+//are evaluated in.
+func (rb Rulebase) exportrules(rules map[string][]Rule) {
+	for path, networks := range rb.networks {
+		for _, nw := range networks {
+			rules[path] = append(rules[path], Rule{Url: wildcardurl(path, nw.wildcard), Network: &Network{Allow: cidrs(nw.allow), Deny: cidrs(nw.deny)}})
+		}
+	}
+	for path, conditionals := range rb.conditional {
+		for _, c := range conditionals {
+			r := Rule{Url: c.url, Conditions: c.conditions, ACL: acl(c.keys)}
+			exportextras(&r, c.window, c.expression, c.captures)
+			rules[path] = append(rules[path], r)
+		}
+	}
+	for path, grants := range rb.timed {
+		for _, g := range grants {
+			r := Rule{Url: g.url, ACL: acl(g.keys)}
+			exportextras(&r, g.window, g.expression, g.captures)
+			rules[path] = append(rules[path], r)
+		}
+	}
+}
+
+//Sets the window, expression and captures of an exported rule
+func exportextras(r *Rule, w *window, e *expression, captures []string) {
+	if w != nil {
+		spec := w.spec
+		r.Window = &spec
+	}
+	if e != nil {
+		r.Expression = e.source
+	}
+	if captures != nil {
+		r.Captures = strings.Join(captures, "/")
+	}
+}
+
+//Returns the URL of a rule for path
+func wildcardurl(path string, wildcard bool) string {
+	if wildcard {
+		return path + "*"
+	}
+	return path
+}
+
+//Converts a key map to an ACL with lists of verbs
+func acl(keys map[string]int) map[string][]string {
+	acl := make(map[string][]string)
+	for subject, flags := range keys {
+		acl[subject] = Verbs(flags)
+	}
+	return acl
+}
+
+//Returns the CIDR ranges of nets
+func cidrs(nets []*net.IPNet) []string {
+	var cidrs []string
+	for _, n := range nets {
+		cidrs = append(cidrs, n.String())
+	}
+	return cidrs
+}
@@ -0,0 +1,192 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const export_test_conf = `---
+inheritance: inherit
+default_access: [GET]
+unknown_host_access: []
+trusted_proxies: [127.0.0.1, 10.0.0.0/8]
+
+defaults:
+  - Url: www.corpB.com/*
+    Access: [GET, POST]
+
+groups:
+  admins: [Jim, Jane]
+  developers: [John, Jack]
+  auditors: []
+
+subjects:
+  Jim:
+    department: it
+
+roles:
+  editor:
+    "*": [GET, PUT]
+
+role_bindings:
+  - Role: editor
+    Subjects: [developers]
+    Scopes: [www.corpA.com/wiki]
+
+rules:
+  - Url: www.corpA.com/admin/*
+    Network:
+      Allow: [10.8.0.0/16]
+    ACL:
+      admins: [PUT, GET]
+      John: []
+  - Url: www.corpA.com/*
+    ACL:
+      developers: [GET]
+  - Url: www.corpA.com/admin/*
+    Conditions:
+      Headers:
+        X-Break-Glass: "1"
+    ACL:
+      John: [GET, DELETE]
+  - Url: www.corpA.com/reports/*
+    Expression: subject.department == "it"
+    Captures: "{year}/*"
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/maintenance
+    Window:
+      NotAfter: 2031-12-31
+      Weekdays: [Sat]
+      Hours: "22:00-06:00"
+    ACL:
+      Jack: [UPDATE]`
+
+func TestExport(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": export_test_conf})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exported, err := rb.Export().Encode("yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, format := range []string{"yaml", "json", "toml"} {
+		data, err := rb.Export().Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(dir, "exported."+format)
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diagnostics := Validate(filename); len(diagnostics) > 0 {
+			t.Errorf("The exported %s configuration is invalid: %v", format, diagnostics)
+		}
+
+		conf, err := Readconfig(filename)
+		if err != nil {
+			t.Fatalf("Reading the exported %s configuration failed (%s)", format, err)
+		}
+		reloaded, err := Build(conf)
+		if err != nil {
+			t.Fatalf("Building the exported %s configuration failed (%s)", format, err)
+		}
+
+		//exporting again must give the same configuration
+		again, err := reloaded.Export().Encode("yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(exported) {
+			t.Errorf("Exporting the %s round trip gave\n%s\ninstead of\n%s", format, again, exported)
+		}
+
+		for _, req := range []*Request{
+			{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1"},
+			{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/users", RemoteAddr: "192.168.1.1"},
+			{Subject: "John", Method: "GET", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1"},
+			{Subject: "John", Method: "DELETE", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1", Header: http.Header{"X-Break-Glass": {"1"}}},
+			{Subject: "Jack", Method: "PUT", Url: "www.corpA.com/wiki/home"},
+			{Subject: "Jack", Method: "GET", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1"},
+			{Subject: "Jim", Method: "GET", Url: "www.corpA.com/reports/2026/q1"},
+			{Subject: "Jim", Method: "GET", Url: "www.corpA.com/reports"},
+			{Subject: "Bob", Method: "POST", Url: "www.corpB.com/form"},
+			{Subject: "Bob", Method: "GET", Url: "www.corpC.com/"},
+		} {
+			expected, err := rb.Explain(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := reloaded.Explain(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Access != expected.Access {
+				t.Errorf("%s %s %s gets access %d from the exported %s configuration instead of %d", req.Subject, req.Method, req.Url, d.Access, format, expected.Access)
+			}
+		}
+	}
+}
+
+//A rule with an empty ACL takes away what the wildcard rule above it grants, so must its export
+func TestExportEmptyACL(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": `---
+rules:
+  - Url: www.public.org/*
+    ACL:
+      anonymous: [GET]
+  - Url: www.public.org/secret*
+    ACL: {}
+  - Url: www.public.org/beta
+    Conditions:
+      Headers:
+        X-Beta: "1"
+    ACL:
+      anonymous: [PUT]`})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []string{"yaml", "json", "toml"} {
+		data, err := rb.Export().Encode(format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		filename := filepath.Join(dir, "exported."+format)
+		err = ioutil.WriteFile(filename, data, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reloaded, err := Load(filename)
+		if err != nil {
+			t.Fatalf("Loading the exported %s configuration failed (%s)", format, err)
+		}
+
+		for _, url := range []string{"www.public.org/index.html", "www.public.org/secret", "www.public.org/secret/x", "www.public.org/beta"} {
+			expected, err := rb.Lookup("anonymous", url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			access, err := reloaded.Lookup("anonymous", url)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if access != expected {
+				t.Errorf("anonymous gets access %d on %s from the exported %s configuration instead of %d", access, url, format, expected)
+			}
+		}
+	}
+}
+
+func TestEncodeUnknownFormat(t *testing.T) {
+	if _, err := New().Export().Encode("xml"); err == nil {
+		t.Errorf("Encoding a configuration as xml should fail")
+	}
+}
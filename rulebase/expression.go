@@ -0,0 +1,660 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	neturl "net/url"
+	"strconv"
+	"strings"
+)
+
+//Expressions are a small, side effect free language modelled on CEL that rules use to decide on
+//attributes of the subject and the request. An expression is compiled once when its rule is
+//added and evaluated with the attributes of each request the rule's URL matches.
+//
+//	expr   = or
+//	or     = and { "||" and }
+//	and    = unary { "&&" unary }
+//	unary  = "!" unary | cmp
+//	cmp    = member [ ( "==" | "!=" | "<" | "<=" | ">" | ">=" | "in" ) member ]
+//	member = primary { "." ident [ "(" [ expr { "," expr } ] ")" ] | "[" expr "]" }
+//	primary = ident | string | number | "true" | "false" | "(" expr ")" | "[" [ expr { "," expr } ] "]"
+//
+//Values are strings, numbers, booleans, lists and attribute maps. Strings have the methods
+//startsWith, endsWith and contains. "x in list" tests membership and "x in map" whether the
+//map has the attribute x. Accessing a missing attribute is an error, and an expression that
+//fails to evaluate is false.
+type expression struct {
+	source string
+	root   exprnode
+}
+
+type exprnode interface {
+	eval(vars map[string]interface{}) (interface{}, error)
+}
+
+type token struct {
+	kind  byte // 'i'dentifier, 's'tring, 'n'umber, 'o'perator or 0 at the end
+	value string
+	pos   int
+}
+
+func compileexpression(source string) (*expression, error) {
+	tokens, err := tokenize(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser{tokens: tokens}
+	root, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != 0 {
+		return nil, errors.New(fmt.Sprintf("unexpected %q at %d", t.value, t.pos))
+	}
+
+	return &expression{source, root}, nil
+}
+
+//Evaluates the expression with vars and reports whether it is true. Errors and results that
+//aren't booleans count as false.
+func (e *expression) match(vars map[string]interface{}) bool {
+	v, err := e.root.eval(vars)
+	b, ok := v.(bool)
+	return err == nil && ok && b
+}
+
+func tokenize(s string) ([]token, error) {
+	var tokens []token
+
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+			j := i + 1
+			for j < len(s) && (s[j] == '_' || 'a' <= s[j] && s[j] <= 'z' || 'A' <= s[j] && s[j] <= 'Z' || '0' <= s[j] && s[j] <= '9') {
+				j++
+			}
+			tokens = append(tokens, token{'i', s[i:j], i})
+			i = j
+		case '0' <= c && c <= '9':
+			j := i + 1
+			for j < len(s) && ('0' <= s[j] && s[j] <= '9' || s[j] == '.') {
+				j++
+			}
+			tokens = append(tokens, token{'n', s[i:j], i})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(s) && s[j] != c; j++ {
+				if s[j] == '\\' && j+1 < len(s) {
+					j++
+				}
+				b.WriteByte(s[j])
+			}
+			if j == len(s) {
+				return nil, errors.New(fmt.Sprintf("unterminated string at %d", i))
+			}
+			tokens = append(tokens, token{'s', b.String(), i})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(s[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errors.New(fmt.Sprintf("unexpected character %q at %d", c, i))
+			}
+			tokens = append(tokens, token{'o', op, i})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, token{0, "end of expression", len(s)}), nil
+}
+
+type parser struct {
+	tokens []token
+	next   int
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.next]
+}
+
+//Consumes the next token if it is the operator op
+func (p *parser) accept(op string) bool {
+	if t := p.peek(); t.kind == 'o' && t.value == op {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		t := p.peek()
+		return errors.New(fmt.Sprintf("expected %q at %d but found %q", op, t.pos, t.value))
+	}
+	return nil
+}
+
+func (p *parser) or() (exprnode, error) {
+	left, err := p.and()
+	for err == nil && p.accept("||") {
+		var right exprnode
+		right, err = p.and()
+		left = logical{"||", left, right}
+	}
+	return left, err
+}
+
+func (p *parser) and() (exprnode, error) {
+	left, err := p.unary()
+	for err == nil && p.accept("&&") {
+		var right exprnode
+		right, err = p.unary()
+		left = logical{"&&", left, right}
+	}
+	return left, err
+}
+
+func (p *parser) unary() (exprnode, error) {
+	if p.accept("!") {
+		operand, err := p.unary()
+		return not{operand}, err
+	}
+	return p.comparison()
+}
+
+func (p *parser) comparison() (exprnode, error) {
+	left, err := p.member()
+	if err != nil {
+		return nil, err
+	}
+
+	t := p.peek()
+	switch {
+	case t.kind == 'o' && (t.value == "==" || t.value == "!=" || t.value == "<" || t.value == "<=" || t.value == ">" || t.value == ">="),
+		t.kind == 'i' && t.value == "in":
+		p.next++
+		right, err := p.member()
+		return compare{t.value, left, right}, err
+	}
+	return left, nil
+}
+
+func (p *parser) member() (exprnode, error) {
+	n, err := p.primary()
+	for err == nil {
+		if p.accept(".") {
+			t := p.peek()
+			if t.kind != 'i' {
+				return nil, errors.New(fmt.Sprintf("expected a name at %d but found %q", t.pos, t.value))
+			}
+			p.next++
+			if p.accept("(") {
+				if t.value != "startsWith" && t.value != "endsWith" && t.value != "contains" {
+					return nil, errors.New(fmt.Sprintf("unknown method %s at %d", t.value, t.pos))
+				}
+				var args []exprnode
+				args, err = p.list(")")
+				n = call{t.value, n, args}
+			} else {
+				n = index{n, literal{t.value}}
+			}
+		} else if p.accept("[") {
+			var key exprnode
+			key, err = p.or()
+			if err == nil {
+				err = p.expect("]")
+			}
+			n = index{n, key}
+		} else {
+			break
+		}
+	}
+	return n, err
+}
+
+//Parses a comma separated list of expressions up to the closing operator end
+func (p *parser) list(end string) ([]exprnode, error) {
+	var items []exprnode
+
+	if p.accept(end) {
+		return items, nil
+	}
+	for {
+		item, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+		if p.accept(end) {
+			return items, nil
+		}
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *parser) primary() (exprnode, error) {
+	t := p.peek()
+	if t.kind == 0 {
+		return nil, errors.New(fmt.Sprintf("unexpected end of expression at %d", t.pos))
+	}
+	p.next++
+
+	switch t.kind {
+	case 's':
+		return literal{t.value}, nil
+	case 'n':
+		f, err := strconv.ParseFloat(t.value, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid number %q at %d", t.value, t.pos))
+		}
+		return literal{f}, nil
+	case 'i':
+		switch t.value {
+		case "true":
+			return literal{true}, nil
+		case "false":
+			return literal{false}, nil
+		}
+		return variable{t.value}, nil
+	case 'o':
+		if t.value == "(" {
+			n, err := p.or()
+			if err == nil {
+				err = p.expect(")")
+			}
+			return n, err
+		} else if t.value == "[" {
+			items, err := p.list("]")
+			return list{items}, err
+		}
+	}
+
+	return nil, errors.New(fmt.Sprintf("unexpected %q at %d", t.value, t.pos))
+}
+
+type literal struct {
+	value interface{}
+}
+
+func (l literal) eval(vars map[string]interface{}) (interface{}, error) {
+	return l.value, nil
+}
+
+type variable struct {
+	name string
+}
+
+func (v variable) eval(vars map[string]interface{}) (interface{}, error) {
+	value, exists := vars[v.name]
+	if !exists {
+		return nil, errors.New(fmt.Sprintf("undefined variable %s", v.name))
+	}
+	return value, nil
+}
+
+type list struct {
+	items []exprnode
+}
+
+func (l list) eval(vars map[string]interface{}) (interface{}, error) {
+	values := make([]interface{}, len(l.items))
+	for i, item := range l.items {
+		v, err := item.eval(vars)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = v
+	}
+	return values, nil
+}
+
+type index struct {
+	operand, key exprnode
+}
+
+//Looks up the attribute key of the map m
+func lookup(m interface{}, key interface{}) (interface{}, bool, error) {
+	k, ok := key.(string)
+	if !ok {
+		return nil, false, errors.New(fmt.Sprintf("attribute names must be strings, not %v", key))
+	}
+
+	switch m := m.(type) {
+	case map[string]interface{}:
+		v, exists := m[k]
+		return v, exists, nil
+	case map[string]string:
+		v, exists := m[k]
+		return v, exists, nil
+	}
+	return nil, false, errors.New(fmt.Sprintf("%v has no attributes", m))
+}
+
+func (i index) eval(vars map[string]interface{}) (interface{}, error) {
+	m, err := i.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	key, err := i.key.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	v, exists, err := lookup(m, key)
+	if err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, errors.New(fmt.Sprintf("no such attribute %v", key))
+	}
+	return v, nil
+}
+
+type call struct {
+	method   string
+	receiver exprnode
+	args     []exprnode
+}
+
+func (c call) eval(vars map[string]interface{}) (interface{}, error) {
+	r, err := c.receiver.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := r.(string)
+	if !ok || len(c.args) != 1 {
+		return nil, errors.New(fmt.Sprintf("%s takes a string and one argument", c.method))
+	}
+	a, err := c.args[0].eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	arg, ok := a.(string)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("the argument of %s must be a string", c.method))
+	}
+
+	switch c.method {
+	case "startsWith":
+		return strings.HasPrefix(s, arg), nil
+	case "endsWith":
+		return strings.HasSuffix(s, arg), nil
+	case "contains":
+		return strings.Contains(s, arg), nil
+	}
+	return nil, errors.New(fmt.Sprintf("unknown method %s", c.method))
+}
+
+//Reports whether a and b are the same string, number or boolean. Lists and maps are never equal.
+func equal(a interface{}, b interface{}) bool {
+	switch a.(type) {
+	case string, float64, bool:
+		return a == b
+	}
+	return false
+}
+
+type not struct {
+	operand exprnode
+}
+
+func (n not) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := n.operand.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("! needs a boolean, not %v", v))
+	}
+	return !b, nil
+}
+
+type logical struct {
+	op          string
+	left, right exprnode
+}
+
+func (l logical) eval(vars map[string]interface{}) (interface{}, error) {
+	v, err := l.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	left, ok := v.(bool)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s needs booleans, not %v", l.op, v))
+	}
+	if l.op == "||" && left || l.op == "&&" && !left {
+		return left, nil
+	}
+
+	v, err = l.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, ok := v.(bool)
+	if !ok {
+		return nil, errors.New(fmt.Sprintf("%s needs booleans, not %v", l.op, v))
+	}
+	return right, nil
+}
+
+type compare struct {
+	op          string
+	left, right exprnode
+}
+
+func (c compare) eval(vars map[string]interface{}) (interface{}, error) {
+	left, err := c.left.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	right, err := c.right.eval(vars)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.op {
+	case "==":
+		return equal(left, right), nil
+	case "!=":
+		return !equal(left, right), nil
+	case "in":
+		if items, ok := right.([]interface{}); ok {
+			for _, item := range items {
+				if equal(item, left) {
+					return true, nil
+				}
+			}
+			return false, nil
+		}
+		_, exists, err := lookup(right, left)
+		return exists, err
+	}
+
+	//ordering comparisons are defined for two numbers or two strings
+	var order int
+	switch l := left.(type) {
+	case float64:
+		r, ok := right.(float64)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("can't compare %v and %v", left, right))
+		}
+		if l < r {
+			order = -1
+		} else if l > r {
+			order = 1
+		}
+	case string:
+		r, ok := right.(string)
+		if !ok {
+			return nil, errors.New(fmt.Sprintf("can't compare %v and %v", left, right))
+		}
+		order = strings.Compare(l, r)
+	default:
+		return nil, errors.New(fmt.Sprintf("can't compare %v and %v", left, right))
+	}
+
+	switch c.op {
+	case "<":
+		return order < 0, nil
+	case "<=":
+		return order <= 0, nil
+	case ">":
+		return order > 0, nil
+	default:
+		return order >= 0, nil
+	}
+}
+
+//Parses the Captures pattern of a rule. The pattern is a path relative to the rule's URL whose
+//segments are either literals, names in braces that capture the segment of the request path at
+//their position, or a final "*" matching the rest of the path.
+func parsecaptures(pattern string) ([]string, error) {
+	segments := strings.Split(strings.Trim(pattern, "/"), "/")
+	for i, s := range segments {
+		if s == "*" && i != len(segments)-1 {
+			return nil, errors.New(fmt.Sprintf("* must be the last segment of %s", pattern))
+		}
+		if strings.ContainsAny(s, "{}") && (len(s) < 3 || s[0] != '{' || s[len(s)-1] != '}') {
+			return nil, errors.New(fmt.Sprintf("invalid capture %s in %s", s, pattern))
+		}
+	}
+	return segments, nil
+}
+
+//Matches the remainder of a request path below a rule's URL against the rule's capture pattern
+//and returns the captured segments by name
+func capture(segments []string, rest string) (map[string]string, bool) {
+	captures := make(map[string]string)
+	if segments == nil {
+		return captures, true
+	}
+
+	parts := strings.Split(strings.Trim(rest, "/"), "/")
+	for i, s := range segments {
+		if s == "*" {
+			return captures, true
+		}
+		if i >= len(parts) {
+			return nil, false
+		}
+		if strings.HasPrefix(s, "{") {
+			if parts[i] == "" {
+				return nil, false
+			}
+			captures[s[1:len(s)-1]] = parts[i]
+		} else if s != parts[i] {
+			return nil, false
+		}
+	}
+	return captures, len(parts) == len(segments)
+}
+
+//Sets the attributes of subject that expressions can use as subject.<name>
+func (rb *Rulebase) SetAttributes(subject string, attributes map[string]string) {
+	rb.attributes[subject] = attributes
+}
+
+//The evaluation of the expressions of rules matching one request. The variables of the request
+//are only computed if there is an expression to evaluate.
+type evaluation struct {
+	rb   Rulebase
+	req  *Request
+	url  string
+	vars map[string]interface{}
+}
+
+//Returns the variables expressions are evaluated with, apart from the path captures:
+//
+//	subject  name, groups and the attributes of the subject, those of the request first
+//	request  method, url, host, path, ip, query and header (with lower case names)
+func (ev *evaluation) variables() map[string]interface{} {
+	if ev.vars != nil {
+		return ev.vars
+	}
+
+	subject := map[string]interface{}{}
+	for name, value := range ev.rb.attributes[ev.req.Subject] {
+		subject[name] = value
+	}
+	for name, value := range ev.req.Attributes {
+		subject[name] = value
+	}
+	subject["name"] = ev.req.Subject
+	groups := []interface{}{}
+	for _, g := range ev.rb.group[ev.req.Subject] {
+		groups = append(groups, g)
+	}
+	subject["groups"] = groups
+
+	host, path := ev.url, "/"
+	if i := strings.IndexByte(ev.url, '/'); i >= 0 {
+		host, path = ev.url[:i], ev.url[i:]
+	}
+	ip := ""
+	if client := ev.rb.clientip(ev.req); client != nil {
+		ip = client.String()
+	}
+	query := map[string]string{}
+	if values, err := neturl.ParseQuery(rawquery(ev.req.Url)); err == nil {
+		for name := range values {
+			query[name] = values.Get(name)
+		}
+	}
+	header := map[string]string{}
+	for name := range ev.req.Header {
+		header[strings.ToLower(name)] = ev.req.Header.Get(name)
+	}
+
+	ev.vars = map[string]interface{}{
+		"subject": subject,
+		"request": map[string]interface{}{
+			"method": strings.ToUpper(ev.req.Method),
+			"url":    ev.url,
+			"host":   host,
+			"path":   path,
+			"ip":     ip,
+			"query":  query,
+			"header": header,
+		},
+	}
+	return ev.vars
+}
+
+//Reports whether the expression of a rule stored for path holds for the request. A rule
+//without an expression always holds.
+func (ev *evaluation) match(e *expression, captures []string, path string) bool {
+	if e == nil {
+		return true
+	}
+
+	rest := ""
+	if strings.HasPrefix(ev.url, path) {
+		rest = ev.url[len(path):]
+	}
+	captured, ok := capture(captures, rest)
+	if !ok {
+		return false
+	}
+
+	vars := make(map[string]interface{})
+	for name, value := range ev.variables() {
+		vars[name] = value
+	}
+	vars["path"] = captured
+	return e.match(vars)
+}
@@ -0,0 +1,152 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const expression_test_conf = `---
+title: "Rulebase with expressions"
+
+groups:
+  managers: [Anna]
+
+subjects:
+  Jim:
+    department: finance
+  John:
+    department: sales
+
+rules:
+  - Url: www.corpA.com/departments/*
+    Expression: subject.department == path.department
+    Captures: "{department}/*"
+    ACL:
+      Jim: [GET]
+      John: [GET]
+  - Url: www.corpA.com/departments/*
+    Expression: '"managers" in subject.groups && request.method == "GET"'
+    ACL:
+      managers: [GET, PUT]
+  - Url: www.corpA.com/tenants/*
+    Expression: request.header["x-tenant"] == subject.tenant
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/reports*
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/reports*
+    Conditions:
+      Query:
+        export: "*"
+    Expression: request.query.export.startsWith("csv")
+    ACL:
+      Jim: [GET, POST]`
+
+func TestAuthorizeExpression(t *testing.T) {
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
+	err := ioutil.WriteFile(config_filename, []byte(expression_test_conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := Load(config_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tenant_header := http.Header{}
+	tenant_header.Set("X-Tenant", "acme")
+
+	cases := []struct {
+		req    Request
+		access int
+	}{
+		{Request{Subject: "Jim", Url: "www.corpA.com/departments/finance/q1"}, GET},
+		{Request{Subject: "Jim", Url: "www.corpA.com/departments/sales/q1"}, 0},
+		{Request{Subject: "John", Url: "www.corpA.com/departments/sales/q1"}, GET},
+		{Request{Subject: "Jack", Url: "www.corpA.com/departments/sales/q1"}, 0},
+		//a final * in the capture pattern also matches nothing
+		{Request{Subject: "Jim", Url: "www.corpA.com/departments/finance"}, GET},
+		{Request{Subject: "Jim", Url: "www.corpA.com/departments/"}, 0},
+		{Request{Subject: "Anna", Method: "get", Url: "www.corpA.com/departments/sales/q1"}, GET | PUT},
+		{Request{Subject: "Anna", Method: "POST", Url: "www.corpA.com/departments/sales/q1"}, 0},
+		//attributes of the request are those of the subject as well
+		{Request{Subject: "Jim", Url: "www.corpA.com/tenants/acme", Header: tenant_header, Attributes: map[string]string{"tenant": "acme"}}, GET},
+		{Request{Subject: "Jim", Url: "www.corpA.com/tenants/acme", Header: tenant_header}, 0},
+		{Request{Subject: "Jim", Url: "www.corpA.com/tenants/acme", Attributes: map[string]string{"tenant": "acme"}}, 0},
+		{Request{Subject: "Jim", Url: "www.corpA.com/reports?export=csv"}, GET | POST},
+		{Request{Subject: "Jim", Url: "www.corpA.com/reports?export=pdf"}, GET},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Authorize(&c.req)
+		if err != nil {
+			t.Errorf("Authorize %+v failed (%s)", c.req, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %+v, should be %d", access, c.req, c.access)
+		}
+	}
+}
+
+func TestExpression(t *testing.T) {
+	vars := map[string]interface{}{
+		"subject": map[string]interface{}{"name": "Jim", "level": 3.0, "groups": []interface{}{"admins"}},
+		"path":    map[string]string{"id": "42"},
+	}
+
+	cases := []struct {
+		source string
+		result bool
+	}{
+		{`true`, true},
+		{`subject.name == "Jim"`, true},
+		{`subject["name"] != 'Jim'`, false},
+		{`subject.level >= 3 && subject.level < 4`, true},
+		{`!(subject.level > 3) || false`, true},
+		{`"admins" in subject.groups`, true},
+		{`path.id in ["1", "42"]`, true},
+		{`"id" in path`, true},
+		{`"owner" in path`, false},
+		{`subject.name.endsWith("im") && subject.name.contains("J")`, true},
+		//missing attributes, type errors and results that aren't booleans are false
+		{`subject.department == "finance"`, false},
+		{`!(subject.department == "finance")`, false},
+		{`subject.level < "4"`, false},
+		{`subject.groups == subject.groups`, false},
+		{`subject.name`, false},
+		{`unknown || true`, false},
+		{`false && unknown`, false},
+	}
+
+	for _, c := range cases {
+		e, err := compileexpression(c.source)
+		if err != nil {
+			t.Errorf("Compiling %s failed (%s)", c.source, err)
+		} else if e.match(vars) != c.result {
+			t.Errorf("%s should be %v", c.source, c.result)
+		}
+	}
+}
+
+func TestInvalidExpression(t *testing.T) {
+	for _, source := range []string{``, `subject.`, `(true`, `"open`, `a == `, `a # b`, `a.exec("rm")`, `[1, 2`, `true false`} {
+		_, err := compileexpression(source)
+		if err == nil {
+			t.Errorf("Compiling %q should fail", source)
+		}
+	}
+
+	for _, r := range []Rule{
+		{Url: "www.corpA.com/x", Expression: "a ==", ACL: map[string][]string{"Jim": {"GET"}}},
+		{Url: "www.corpA.com/x/*", Captures: "{id}", ACL: map[string][]string{"Jim": {"GET"}}},
+		{Url: "www.corpA.com/x/*", Expression: "true", Captures: "*/{id}", ACL: map[string][]string{"Jim": {"GET"}}},
+		{Url: "www.corpA.com/x/*", Expression: "true", Captures: "{id", ACL: map[string][]string{"Jim": {"GET"}}},
+	} {
+		err := New().Add(&r)
+		if err == nil {
+			t.Errorf("Adding rule %+v should fail", r)
+		}
+	}
+}
@@ -0,0 +1,360 @@
+package rulebase
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+//The file patterns read from a configuration directory
+var configpatterns = []string{"*.yml", "*.yaml", "*.json", "*.toml"}
+
+//Returns the format of a configuration file, yaml, json or toml, by its extension or, for other
+//extensions, by its content
+func configformat(filename string, data []byte) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yml", ".yaml":
+		return "yaml"
+	case ".json":
+		return "json"
+	case ".toml":
+		return "toml"
+	}
+
+	//a configuration is a mapping, so a document starting with a table header or a key = value
+	//pair is TOML
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "{") {
+			return "json"
+		}
+		if strings.HasPrefix(line, "[") || tomlkey.MatchString(line) {
+			return "toml"
+		}
+		break
+	}
+	return "yaml"
+}
+
+var tomlkey = regexp.MustCompile(`^("[^"]*"|[A-Za-z0-9_-]+)(\s*\.\s*("[^"]*"|[A-Za-z0-9_-]+))*\s*=`)
+
+//Parses a configuration document in the format of the file into a YAML node, so that decoding
+//and validation are the same for every format. JSON and TOML documents are converted; the nodes
+//of JSON documents have the line and column of their value, those of TOML documents the line of
+//their key, or of the table they are defined in, but no column.
+func parsedocument(filename string, data []byte) (*yaml.Node, error) {
+	var root yaml.Node
+
+	if issnapshot(data) {
+		return nil, errors.New("a rulebase snapshot, not a configuration")
+	}
+	switch configformat(filename, data) {
+	case "json":
+		return jsondocument(data)
+	case "yaml":
+		err := yaml.Unmarshal(data, &root)
+		if err != nil {
+			return nil, err
+		}
+		return &root, nil
+	}
+
+	var doc map[string]interface{}
+	_, err := toml.Decode(string(data), &doc)
+	if err != nil {
+		var pe toml.ParseError
+		if errors.As(err, &pe) {
+			return nil, errors.New(fmt.Sprintf("line %d: %s", pe.Position.Line, pe.Message))
+		}
+		return nil, err
+	}
+
+	root.Kind = yaml.DocumentNode
+	root.Content = []*yaml.Node{tomlnode(doc, "", 1, tomllines(string(data)))}
+	return &root, nil
+}
+
+//Parses a JSON document into a YAML node. JSON is parsed on its own rather than as YAML, which
+//rejects some valid JSON documents, e.g. strings with escaped surrogate pairs.
+func jsondocument(data []byte) (*yaml.Node, error) {
+	var root yaml.Node
+
+	p := jsonparser{data: data, dec: json.NewDecoder(bytes.NewReader(data))}
+	p.dec.UseNumber()
+	for i, b := range data {
+		if b == '\n' {
+			p.lines = append(p.lines, i+1)
+		}
+	}
+
+	n, err := p.value()
+	if err == io.EOF {
+		return &root, nil
+	}
+	if err == nil {
+		if _, err = p.dec.Token(); err == nil {
+			err = errors.New("data after the end of the document")
+		} else if err == io.EOF {
+			err = nil
+		}
+	}
+	if err != nil {
+		var se *json.SyntaxError
+		if errors.As(err, &se) {
+			line, _ := p.position(int(se.Offset))
+			return nil, errors.New(fmt.Sprintf("line %d: %s", line, se))
+		}
+		return nil, err
+	}
+
+	root.Kind = yaml.DocumentNode
+	root.Content = []*yaml.Node{n}
+	return &root, nil
+}
+
+//Converts the tokens of a JSON document into YAML nodes. lines holds the offsets the lines of
+//data after the first one start at.
+type jsonparser struct {
+	data  []byte
+	dec   *json.Decoder
+	lines []int
+}
+
+//Returns the line and column of the byte at offset
+func (p *jsonparser) position(offset int) (int, int) {
+	line := sort.SearchInts(p.lines, offset+1)
+	start := 0
+	if line > 0 {
+		start = p.lines[line-1]
+	}
+	if offset > len(p.data) {
+		offset = len(p.data)
+	}
+	return line + 1, utf8.RuneCount(p.data[start:offset]) + 1
+}
+
+//Returns the line and column of the token the decoder reads next
+func (p *jsonparser) next() (int, int) {
+	offset := int(p.dec.InputOffset())
+	for offset < len(p.data) && strings.IndexByte(" \t\r\n,:", p.data[offset]) >= 0 {
+		offset++
+	}
+	return p.position(offset)
+}
+
+//Reads the next value of the document and returns its node
+func (p *jsonparser) value() (*yaml.Node, error) {
+	line, column := p.next()
+	t, err := p.dec.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	n := &yaml.Node{Kind: yaml.ScalarNode, Line: line, Column: column}
+	switch t := t.(type) {
+	case json.Delim:
+		if t == '{' {
+			n.Kind, n.Tag = yaml.MappingNode, "!!map"
+		} else {
+			n.Kind, n.Tag = yaml.SequenceNode, "!!seq"
+		}
+		for p.dec.More() {
+			if n.Kind == yaml.MappingNode {
+				line, column := p.next()
+				key, err := p.dec.Token()
+				if err != nil {
+					return nil, err
+				}
+				n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.(string), Line: line, Column: column})
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			n.Content = append(n.Content, v)
+		}
+		//the closing delimiter
+		if _, err := p.dec.Token(); err != nil {
+			return nil, err
+		}
+	case string:
+		n.Tag, n.Value = "!!str", t
+	case json.Number:
+		n.Tag, n.Value = "!!float", t.String()
+		if _, err := t.Int64(); err == nil {
+			n.Tag = "!!int"
+		}
+	case bool:
+		n.Tag, n.Value = "!!bool", strconv.FormatBool(t)
+	case nil:
+		n.Tag, n.Value = "!!null", "null"
+	}
+	return n, nil
+}
+
+//Returns the lines the keys and tables of a TOML document are defined on, by their path. The
+//elements of arrays of tables are numbered: the Url of the second rule is rules.1.Url.
+func tomllines(data string) map[string]int {
+	lines := make(map[string]int)
+	tables := make(map[string]int) //number of elements of each array of tables
+	table := ""
+
+	//returns the path of a header's keys with the current element of the arrays of tables in it
+	resolve := func(keys []string) string {
+		var path []string
+		for _, k := range keys {
+			path = append(path, k)
+			if n, exists := tables[strings.Join(path, ".")]; exists {
+				path = append(path, strconv.Itoa(n-1))
+			}
+		}
+		return strings.Join(path, ".")
+	}
+
+	for i, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "[["):
+			keys := tomlkeys(strings.TrimPrefix(line, "[["))
+			path := resolve(keys[:len(keys)-1])
+			if path != "" {
+				path += "."
+			}
+			path += keys[len(keys)-1]
+			tables[path]++
+			table = path + "." + strconv.Itoa(tables[path]-1)
+			if _, exists := lines[path]; !exists {
+				lines[path] = i + 1
+			}
+			lines[table] = i + 1
+		case strings.HasPrefix(line, "["):
+			table = resolve(tomlkeys(strings.TrimPrefix(line, "[")))
+			lines[table] = i + 1
+		case tomlkey.MatchString(line):
+			path := strings.Join(tomlkeys(line), ".")
+			if table != "" {
+				path = table + "." + path
+			}
+			lines[path] = i + 1
+		}
+	}
+
+	return lines
+}
+
+//Returns the keys of the dotted key at the start of s, up to a ']' or '='
+func tomlkeys(s string) []string {
+	var keys []string
+
+	for {
+		s = strings.TrimSpace(s)
+		var key string
+		if strings.HasPrefix(s, "\"") {
+			end := strings.IndexByte(s[1:], '"')
+			if end < 0 {
+				return append(keys, s[1:])
+			}
+			key, s = s[1:end+1], s[end+2:]
+		} else {
+			end := strings.IndexAny(s, ".]= \t")
+			if end < 0 {
+				return append(keys, s)
+			}
+			key, s = s[:end], s[end:]
+		}
+		keys = append(keys, key)
+
+		s = strings.TrimSpace(s)
+		if !strings.HasPrefix(s, ".") {
+			return keys
+		}
+		s = s[1:]
+	}
+}
+
+//Converts a value decoded from TOML at path into a YAML node. line is the line of the key or
+//table the value belongs to.
+func tomlnode(v interface{}, path string, line int, lines map[string]int) *yaml.Node {
+	child := func(key string) (string, int) {
+		p := key
+		if path != "" {
+			p = path + "." + key
+		}
+		if l, exists := lines[p]; exists {
+			return p, l
+		}
+		return p, line
+	}
+
+	switch v := v.(type) {
+	case map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Line: line}
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Slice(keys, func(i, j int) bool {
+			_, a := child(keys[i])
+			_, b := child(keys[j])
+			if a != b {
+				return a < b
+			}
+			return keys[i] < keys[j]
+		})
+		for _, k := range keys {
+			p, l := child(k)
+			n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k, Line: l}, tomlnode(v[k], p, l, lines))
+		}
+		return n
+	case []map[string]interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		for i, e := range v {
+			p, l := child(strconv.Itoa(i))
+			n.Content = append(n.Content, tomlnode(e, p, l, lines))
+		}
+		return n
+	case []interface{}:
+		n := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Line: line}
+		for i, e := range v {
+			p, l := child(strconv.Itoa(i))
+			n.Content = append(n.Content, tomlnode(e, p, l, lines))
+		}
+		return n
+	case string:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v, Line: line}
+	case bool:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: strconv.FormatBool(v), Line: line}
+	case int64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.FormatInt(v, 10), Line: line}
+	case float64:
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!float", Value: strconv.FormatFloat(v, 'g', -1, 64), Line: line}
+	case time.Time:
+		//local dates and times have no time zone, keep them the way they were written
+		layout := time.RFC3339Nano
+		switch v.Location().String() {
+		case "date-local":
+			layout = "2006-01-02"
+		case "datetime-local":
+			layout = "2006-01-02T15:04:05.999999999"
+		case "time-local":
+			layout = "15:04"
+		}
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: v.Format(layout), Line: line}
+	}
+	return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: fmt.Sprint(v), Line: line}
+}
@@ -0,0 +1,212 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const format_test_yaml = `---
+inheritance: inherit
+default_access: [GET]
+
+groups:
+  admins: [Jim]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      John: [GET, POST]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      admins: [GET, PUT]
+      John: []
+  - Url: www.corpA.com/maintenance
+    Window:
+      NotAfter: 2031-12-31
+      Hours: "22:00-06:00"
+    ACL:
+      Jack: [PUT]`
+
+const format_test_json = `{
+  "inheritance": "inherit",
+  "default_access": ["GET"],
+  "groups": {"admins": ["Jim"]},
+  "rules": [
+    {"Url": "www.corpA.com/*", "ACL": {"John": ["GET", "POST"]}},
+    {"Url": "www.corpA.com/admin/*", "ACL": {"admins": ["GET", "PUT"], "John": []}},
+    {
+      "Url": "www.corpA.com/maintenance",
+      "Window": {"NotAfter": "2031-12-31", "Hours": "22:00-06:00"},
+      "ACL": {"Jack": ["PUT"]}
+    }
+  ]
+}`
+
+const format_test_toml = `# the same configuration in TOML
+inheritance = "inherit"
+default_access = ["GET"]
+
+[groups]
+admins = ["Jim"]
+
+[[rules]]
+Url = "www.corpA.com/*"
+ACL = {John = ["GET", "POST"]}
+
+[[rules]]
+Url = "www.corpA.com/admin/*"
+[rules.ACL]
+admins = ["GET", "PUT"]
+John = []
+
+[[rules]]
+Url = "www.corpA.com/maintenance"
+Window = {NotAfter = 2031-12-31, Hours = "22:00-06:00"}
+ACL.Jack = ["PUT"]`
+
+func TestFormats(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{
+		"conf.yml":  format_test_yaml,
+		"conf.json": format_test_json,
+		"conf.toml": format_test_toml,
+		"json.conf": format_test_json,
+		"toml.conf": format_test_toml,
+	})
+	files := map[string]string{
+		"conf.json":                 filepath.Join(dir, "conf.json"),
+		"conf.toml":                 filepath.Join(dir, "conf.toml"),
+		"json.conf":                 filepath.Join(dir, "json.conf"),
+		"toml.conf":                 filepath.Join(dir, "toml.conf"),
+		"a directory of JSON files": writetestfiles(t, map[string]string{"conf.json": format_test_json}),
+		"a directory of TOML files": writetestfiles(t, map[string]string{"conf.toml": format_test_toml}),
+	}
+	reference, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, err := reference.Export().Encode("yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, filename := range files {
+		for _, d := range Validate(filename) {
+			t.Errorf("%s: unexpected diagnostic %s", name, d)
+		}
+		rb, err := Load(filename)
+		if err != nil {
+			t.Errorf("Loading %s failed (%s)", name, err)
+			continue
+		}
+		exported, err := rb.Export().Encode("yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(exported) != string(expected) {
+			t.Errorf("%s loads into\n%s\ninstead of\n%s", name, exported, expected)
+		}
+	}
+}
+
+func TestConfigFormat(t *testing.T) {
+	for _, test := range []struct {
+		filename string
+		data     string
+		format   string
+	}{
+		{"conf.yml", format_test_toml, "yaml"},
+		{"conf.YAML", "", "yaml"},
+		{"conf.json", format_test_yaml, "json"},
+		{"conf.toml", format_test_yaml, "toml"},
+		{"conf", format_test_yaml, "yaml"},
+		{"conf", format_test_json, "json"},
+		{"conf", format_test_toml, "toml"},
+		{"conf", "\n[[rules]]\nUrl = \"www.corpA.com\"", "toml"},
+		{"conf", "rules:\n  - Url: www.corpA.com", "yaml"},
+		{"conf", "", "yaml"},
+	} {
+		if format := configformat(test.filename, []byte(test.data)); format != test.format {
+			t.Errorf("%s %q is %s instead of %s", test.filename, test.data, format, test.format)
+		}
+	}
+}
+
+func TestValidateFormats(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{
+		"conf.json": `{
+  "inheritance": "merge",
+  "rules": [
+    {"Url": "www.corp*.com/*", "ACL": {"Jim": ["GET"]}},
+    {"Url": "www.corpA.com/*", "ACL": {"Jim": ["GET", "FETCH"], "John": "allow"}}
+  ]
+}`,
+		"conf.toml": `inheritance = "merge"
+
+[[rules]]
+Url = "www.corp*.com/*"
+ACL = {Jim = ["GET"]}
+
+[[rules]]
+Url = "www.corpA.com/*"
+[rules.ACL]
+Jim = ["GET", "FETCH"]
+John = "allow"`,
+		"syntax.toml": "[[rules]]\nUrl = \"www.corpA.com/*\"\nACL = {Jim = [\"GET\"]\n",
+	})
+
+	for name, expected := range map[string][]string{
+		"conf.json": {
+			"conf.json:2:18: Unknown inheritance mode merge",
+			"conf.json:4:13: illegal wildcard in www.corp*.com/*",
+			"conf.json:5:55: unknown HTTP verb FETCH",
+			"conf.json:5:73: the access of John (a list of HTTP verbs) must be a list",
+		},
+		"conf.toml": {
+			"conf.toml:1: Unknown inheritance mode merge",
+			"conf.toml:4: illegal wildcard in www.corp*.com/*",
+			"conf.toml:10: unknown HTTP verb FETCH",
+			"conf.toml:11: the access of John (a list of HTTP verbs) must be a list",
+		},
+		"syntax.toml": {
+			"syntax.toml:3: ",
+		},
+	} {
+		diagnostics := Validate(filepath.Join(dir, name))
+		for i, d := range diagnostics {
+			message := strings.TrimPrefix(d.String(), dir+string(filepath.Separator))
+			if i >= len(expected) || !strings.HasPrefix(message, expected[i]) {
+				t.Errorf("Unexpected diagnostic %s", message)
+			}
+		}
+		if len(diagnostics) != len(expected) {
+			t.Errorf("Got %d diagnostics for %s, expected %d", len(diagnostics), name, len(expected))
+		}
+	}
+}
+
+//Escaped surrogate pairs are valid JSON but not valid YAML
+func TestJSONSurrogatePair(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.json": `{
+  "title": "\ud83d\ude00 policies",
+  "rules": [{"Url": "www.corpA.com/*", "ACL": {"J\u00fcrgen": ["GET"]}}]
+}`})
+
+	for _, d := range Validate(filepath.Join(dir, "conf.json")) {
+		t.Errorf("Unexpected diagnostic %s", d)
+	}
+	conf, err := Readconfig(filepath.Join(dir, "conf.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if conf.Title != "😀 policies" {
+		t.Errorf("Wrong title %q", conf.Title)
+	}
+	rb, err := Build(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access, err := rb.Lookup("Jürgen", "www.corpA.com/"); err != nil || access != GET {
+		t.Errorf("Wrong authorization value %d (%v) for Jürgen, should be %d", access, err, GET)
+	}
+}
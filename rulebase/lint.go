@@ -0,0 +1,199 @@
+package rulebase
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+//Loads the configuration file or directory filename like Load and returns warnings about risky
+//or pointless definitions, sorted by file and position:
+//
+//	- rules that grant nothing the nearest wildcard rule above them doesn't grant already
+//	- group members and declared subjects that no rule or role binding grants anything
+//	- groups without members
+//	- rules granting anonymous other verbs than GET
+//	- wildcard rules for a whole host
+//	- rules that can never apply: conditional rules with the same conditions as an earlier
+//	  one, rules whose window has closed for good and rules behind a network restriction that
+//	  refuses every client
+func Lint(filename string) ([]Diagnostic, error) {
+	var warnings []Diagnostic
+
+	conf, err := Readconfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	rb, err := Build(conf)
+	if err != nil {
+		return nil, err
+	}
+
+	warn := func(at position, format string, args ...interface{}) {
+		warnings = append(warnings, Diagnostic{at.file, at.line, at.column, fmt.Sprintf(format, args...)})
+	}
+
+	//names that are granted something, directly or as a group
+	granted := make(map[string]bool)
+	for _, b := range conf.RoleBindings {
+		for _, s := range b.Subjects {
+			granted[s] = true
+		}
+	}
+
+	now := rb.now()
+	for i, r := range conf.Rules {
+		at := conf.origin(i)
+		url, err := canonicalrule(r.Url)
+		if err != nil {
+			continue
+		}
+		path := strings.TrimSuffix(url, "*")
+		for subject := range r.ACL {
+			granted[subject] = true
+		}
+
+		if access, exists := r.ACL["anonymous"]; exists {
+			if flags, _ := accessflags(access); flags&^GET != 0 {
+				warn(at, "rule for %s grants anonymous %s", r.Url, strings.Join(Verbs(flags&^GET), " "))
+			}
+		}
+		if strings.HasSuffix(url, "*") && !strings.Contains(strings.TrimSuffix(path, "/"), "/") {
+			warn(at, "wildcard rule for %s applies to the whole host", r.Url)
+		}
+
+		if len(r.ACL) == 0 {
+			continue
+		}
+		if r.Conditions == nil && r.Window == nil && r.Expression == "" {
+			if ancestor, redundant := rb.redundant(path); redundant {
+				warn(at, "rule for %s grants nothing the rule for %s doesn't grant already", url, ancestor)
+			}
+		}
+		if r.Conditions != nil {
+			for j := 0; j < i; j++ {
+				earlier := conf.Rules[j]
+				if u, _ := canonicalrule(earlier.Url); u == url && earlier.Window == nil && earlier.Expression == "" && reflect.DeepEqual(earlier.Conditions, r.Conditions) {
+					warn(at, "rule for %s never applies, the rule at %s has the same conditions", r.Url, conf.origin(j))
+					break
+				}
+			}
+		}
+		if r.Window != nil {
+			if w, err := r.Window.parse(); err == nil && w.expired(now) {
+				warn(at, "rule for %s never applies again, its window closed on %s", r.Url, w.not_after.Format("2006-01-02"))
+			}
+		}
+		if gate := rb.refusesall(path); gate != "" {
+			warn(at, "rule for %s never applies, the network restriction of %s refuses every client", r.Url, gate)
+		}
+	}
+
+	var groups, subjects []string
+	for name := range conf.Groups {
+		groups = append(groups, name)
+	}
+	sort.Strings(groups)
+	for name := range conf.Subjects {
+		subjects = append(subjects, name)
+	}
+	sort.Strings(subjects)
+
+	for _, name := range groups {
+		if len(conf.Groups[name]) == 0 {
+			warn(conf.defined["groups "+name], "group %s has no members", name)
+		}
+	}
+
+	//subjects are covered if they or one of their groups are granted something
+	reported := make(map[string]bool)
+	covered := func(subject string) bool {
+		if granted[subject] {
+			return true
+		}
+		for _, g := range rb.group[subject] {
+			if granted[g] {
+				return true
+			}
+		}
+		return false
+	}
+	for _, name := range subjects {
+		if !covered(name) {
+			warn(conf.defined["subjects "+name], "subject %s isn't granted anything", name)
+			reported[name] = true
+		}
+	}
+	for _, name := range groups {
+		for _, member := range conf.Groups[name] {
+			if !covered(member) && !reported[member] {
+				warn(conf.defined["groups "+name], "subject %s of group %s isn't granted anything", member, name)
+				reported[member] = true
+			}
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		a, b := warnings[i], warnings[j]
+		if a.File != b.File {
+			return a.File < b.File
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return warnings, nil
+}
+
+//Reports whether the ACL stored for path grants nothing the ACL of the nearest wildcard prefix
+//above it doesn't grant already and returns that prefix. With inheritance an ACL is redundant if
+//its entries are those of the ancestor, otherwise it has to equal the ancestor's.
+func (rb Rulebase) redundant(path string) (string, bool) {
+	var keys map[string]int
+	for _, m := range rb.tree.MatchAll(path) {
+		if m.Path == path && !m.Wildcard {
+			keys = m.Keys
+			continue
+		}
+		if !m.Wildcard || len(m.Path) >= len(path) || rb.placeholders[m.Path] {
+			continue
+		}
+
+		ancestor := m.Path + "*"
+		if rb.inherit {
+			for subject, flags := range keys {
+				if v, exists := m.Keys[subject]; !exists || v != flags {
+					return ancestor, false
+				}
+			}
+			return ancestor, true
+		}
+		return ancestor, reflect.DeepEqual(keys, m.Keys)
+	}
+	return "", false
+}
+
+//Returns the URL of a network restriction that applies to path and refuses every client, or ""
+//if there is none
+func (rb Rulebase) refusesall(path string) string {
+	for _, m := range rb.gates.MatchAll(path) {
+		for _, nw := range rb.networks[m.Path] {
+			if m.Wildcard && !nw.wildcard {
+				continue
+			}
+			var v4, v6 bool
+			for _, n := range nw.deny {
+				if ones, bits := n.Mask.Size(); ones == 0 {
+					v4, v6 = v4 || bits == 32, v6 || bits == 128
+				}
+			}
+			if v4 && v6 && nw.wildcard {
+				return m.Path + "*"
+			} else if v4 && v6 {
+				return m.Path
+			}
+		}
+	}
+	return ""
+}
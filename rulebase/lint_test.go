@@ -0,0 +1,97 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const lint_test_conf = `---
+title: "Configuration with smells"
+
+groups:
+  admins: [Jim]
+  auditors: []
+  contractors: [Jane]
+  developers: [John]
+
+subjects:
+  Jim: {}
+  Joe: {}
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      admins: [GET, PUT]
+  - Url: www.corpA.com/wiki/*
+    ACL:
+      anonymous: [GET, POST]
+      developers: [GET]
+  - Url: www.corpA.com/wiki/howto
+    ACL:
+      anonymous: [GET, POST]
+      developers: [GET]
+  - Url: www.corpA.com/reports/*
+    Conditions:
+      Method: [GET]
+    ACL:
+      developers: [GET]
+  - Url: www.corpA.com/reports/*
+    Conditions:
+      Method: [GET]
+    ACL:
+      John: [GET]
+  - Url: www.corpA.com/audit/*
+    Window:
+      NotAfter: 2020-01-31
+    ACL:
+      John: [GET]
+  - Url: www.corpA.com/legacy/*
+    Network:
+      Deny: [0.0.0.0/0, "::/0"]
+  - Url: www.corpA.com/legacy/app
+    ACL:
+      admins: [GET]`
+
+func TestLint(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": lint_test_conf})
+
+	expected := []string{
+		"conf.yml:6:3: group auditors has no members",
+		"conf.yml:7:3: subject Jane of group contractors isn't granted anything",
+		"conf.yml:12:3: subject Joe isn't granted anything",
+		"conf.yml:15:5: wildcard rule for www.corpA.com/* applies to the whole host",
+		"conf.yml:18:5: rule for www.corpA.com/wiki/* grants anonymous POST",
+		"conf.yml:22:5: rule for www.corpA.com/wiki/howto grants anonymous POST",
+		"conf.yml:22:5: rule for www.corpa.com/wiki/howto grants nothing the rule for www.corpa.com/wiki/* doesn't grant already",
+		"conf.yml:31:5: rule for www.corpA.com/reports/* never applies, the rule at ",
+		"conf.yml:36:5: rule for www.corpA.com/audit/* never applies again, its window closed on 2020-01-31",
+		"conf.yml:44:5: rule for www.corpA.com/legacy/app never applies, the network restriction of www.corpa.com/legacy/* refuses every client",
+	}
+
+	warnings, err := Lint(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range warnings {
+		message := strings.TrimPrefix(w.String(), dir+string(filepath.Separator))
+		if i >= len(expected) || !strings.HasPrefix(message, expected[i]) {
+			t.Errorf("Unexpected warning %s", message)
+		}
+	}
+	if len(warnings) != len(expected) {
+		t.Errorf("Got %d warnings, expected %d", len(warnings), len(expected))
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": roles_test_conf})
+
+	warnings, err := Lint(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, w := range warnings {
+		t.Errorf("Unexpected warning %s", w)
+	}
+}
@@ -0,0 +1,158 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+//Network restricts the client addresses a URL can be reached from. Allow and Deny are lists of
+//CIDR ranges or single IP addresses. A client in any Deny range is refused and, if Allow is not
+//empty, so is every client outside all Allow ranges.
+type Network struct {
+	Allow []string `yaml:"Allow,omitempty"`
+	Deny  []string `yaml:"Deny,omitempty"`
+}
+
+//A Network parsed into IP ranges. Restrictions are kept in a tree of their own (gates) next to
+//the ACL tree, so they don't change which ACL a request matches. A restriction added with a
+//wildcard rule also applies to every URL below it.
+type network struct {
+	wildcard bool
+	allow    []*net.IPNet
+	deny     []*net.IPNet
+}
+
+func (nw *Network) parse(wildcard bool) (*network, error) {
+	var err error
+	p := network{wildcard: wildcard}
+
+	p.allow, err = parsenets(nw.Allow)
+	if err != nil {
+		return nil, err
+	}
+	p.deny, err = parsenets(nw.Deny)
+	if err != nil {
+		return nil, err
+	}
+
+	return &p, nil
+}
+
+//Parses a list of CIDR ranges, single addresses are taken as a range of one
+func parsenets(cidrs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+
+	for _, c := range cidrs {
+		if !strings.Contains(c, "/") {
+			ip := net.ParseIP(c)
+			if ip == nil {
+				return nil, errors.New(fmt.Sprintf("invalid IP address %s", c))
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+
+	return nets, nil
+}
+
+func containsip(nets []*net.IPNet, ip net.IP) bool {
+	for _, n := range nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+//Reports whether a client with the address ip may reach the URL. An unknown address is refused.
+func (nw *network) allows(ip net.IP) bool {
+	if ip == nil || containsip(nw.deny, ip) {
+		return false
+	}
+	return len(nw.allow) == 0 || containsip(nw.allow, ip)
+}
+
+//Sets the addresses of the proxies that are trusted to report the client address of a request
+//in the X-Forwarded-For and X-Real-IP headers.
+func (rb *Rulebase) SetTrustedProxies(cidrs []string) error {
+	nets, err := parsenets(cidrs)
+	if err != nil {
+		return err
+	}
+
+	rb.trusted = nets
+	return nil
+}
+
+//Returns the address of the client that made req. The peer address (RemoteAddr) is the client
+//unless it is a trusted proxy. In that case the X-Forwarded-For chain is followed from the
+//right, skipping trusted proxies, to the first address that isn't one. Without an
+//X-Forwarded-For header X-Real-IP is used. Returns nil if the address can't be determined.
+func (rb Rulebase) clientip(req *Request) net.IP {
+	ip := parseip(req.RemoteAddr)
+	if ip == nil || !containsip(rb.trusted, ip) {
+		return ip
+	}
+
+	if forwarded := req.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		hops := strings.Split(strings.Join(forwarded, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip = parseip(hops[i])
+			if ip == nil || !containsip(rb.trusted, ip) {
+				return ip
+			}
+		}
+		return ip
+	}
+
+	if real_ip := req.Header.Get("X-Real-IP"); real_ip != "" {
+		return parseip(real_ip)
+	}
+
+	return ip
+}
+
+//Parses an IP address with an optional port as found in RemoteAddr and forwarding headers
+func parseip(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	return net.ParseIP(strings.Trim(s, "[]"))
+}
+
+//Reports whether the client of req may reach url, i.e. whether it passes the network
+//restrictions of every rule matching url.
+func (rb Rulebase) reachable(url string, req *Request) bool {
+	var ip net.IP
+	resolved := false
+
+	for _, m := range rb.gates.MatchAll(url) {
+		for _, nw := range rb.networks[m.Path] {
+			if m.Wildcard && !nw.wildcard {
+				continue
+			}
+			if !resolved {
+				ip, resolved = rb.clientip(req), true
+			}
+			if !nw.allows(ip) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,109 @@
+package rulebase
+
+import (
+	"net/http"
+	"testing"
+)
+
+const network_test_conf = `---
+title: "Rulebase with network restrictions"
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      Jim: [GET, POST]
+      John: [GET]
+  - Url: www.corpA.com/admin*
+    Network:
+      Allow: [10.8.0.0/16, "fd00:8::/32"]
+      Deny: [10.8.5.0/24]
+    ACL:
+      Jim: [GET, POST]
+  - Url: www.corpA.com/admin/status
+    ACL:
+      John: [GET]
+  - Url: www.corpA.com/printer
+    Network:
+      Allow: [192.168.1.10]`
+
+func TestAuthorizeNetwork(t *testing.T) {
+	rb := createtestrulebase(t, network_test_conf)
+
+	cases := []struct {
+		subject, url, ip string
+		access           int
+	}{
+		{"Jim", "www.corpA.com/admin", "10.8.1.1:51234", GET | POST},
+		{"Jim", "www.corpA.com/admin/users", "10.8.1.1", GET | POST},
+		{"Jim", "www.corpA.com/admin/users", "[fd00:8::1]:443", GET | POST},
+		{"Jim", "www.corpA.com/admin/users", "10.9.1.1", 0},
+		{"Jim", "www.corpA.com/admin/users", "10.8.5.7", 0},
+		{"Jim", "www.corpA.com/admin/users", "", 0},
+		//the restriction of www.corpA.com/admin* also covers the more specific rule
+		{"John", "www.corpA.com/admin/status", "10.8.1.1", GET},
+		{"John", "www.corpA.com/admin/status", "172.16.0.1", 0},
+		//a restriction without ACL doesn't hide the www.corpA.com/* grant
+		{"John", "www.corpA.com/printer", "192.168.1.10", GET},
+		{"John", "www.corpA.com/printer", "192.168.1.11", 0},
+		{"John", "www.corpA.com/printers", "192.168.1.11", GET},
+		{"John", "www.corpA.com/home", "", GET},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Authorize(&Request{Subject: c.subject, Url: c.url, RemoteAddr: c.ip})
+		if err != nil {
+			t.Errorf("Authorize %s:%s from %s failed (%s)", c.subject, c.url, c.ip, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s from %s, should be %d", access, c.subject, c.url, c.ip, c.access)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	rb := New()
+	err := rb.SetTrustedProxies([]string{"127.0.0.1", "10.0.0.0/24"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		remote_addr, forwarded_for, real_ip, client string
+	}{
+		{"192.168.1.1:1234", "", "", "192.168.1.1"},
+		//headers of untrusted peers are ignored
+		{"192.168.1.1:1234", "10.8.1.1", "10.8.1.1", "192.168.1.1"},
+		{"127.0.0.1:1234", "", "10.8.1.1", "10.8.1.1"},
+		{"127.0.0.1:1234", "10.8.1.1", "10.8.1.2", "10.8.1.1"},
+		{"127.0.0.1:1234", "6.6.6.6, 10.8.1.1, 10.0.0.5", "", "10.8.1.1"},
+		{"127.0.0.1:1234", "10.0.0.7, 10.0.0.5", "", "10.0.0.7"},
+		{"127.0.0.1:1234", "garbage, 10.0.0.5", "", "<nil>"},
+	}
+
+	for _, c := range cases {
+		header := http.Header{}
+		if c.forwarded_for != "" {
+			header.Set("X-Forwarded-For", c.forwarded_for)
+		}
+		if c.real_ip != "" {
+			header.Set("X-Real-IP", c.real_ip)
+		}
+
+		ip := rb.clientip(&Request{RemoteAddr: c.remote_addr, Header: header})
+		if ip.String() != c.client {
+			t.Errorf("Client of %s (X-Forwarded-For: %q, X-Real-IP: %q) should be %s not %s", c.remote_addr, c.forwarded_for, c.real_ip, c.client, ip)
+		}
+	}
+}
+
+func TestInvalidNetwork(t *testing.T) {
+	for _, nw := range []Network{
+		{Allow: []string{"10.8.0.0/33"}},
+		{Deny: []string{"corp-vpn"}},
+	} {
+		rb := New()
+		err := rb.Add(&Rule{Url: "www.corpA.com/admin", Network: &nw, ACL: map[string][]string{"Jim": {"GET"}}})
+		if err == nil {
+			t.Errorf("Adding a rule with network %+v should fail", nw)
+		}
+	}
+}
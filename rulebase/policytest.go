@@ -0,0 +1,104 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+//PolicyTest asserts the decision for a request. Tests are listed in the tests section of a
+//configuration, next to the rules they check, or in separate files with only a tests section.
+//Method defaults to GET and Expect is allow or deny.
+type PolicyTest struct {
+	Name       string            `yaml:"name,omitempty"`
+	Subject    string            `yaml:"subject"`
+	Method     string            `yaml:"method,omitempty"`
+	Url        string            `yaml:"url"`
+	Header     map[string]string `yaml:"header,omitempty"`
+	RemoteAddr string            `yaml:"remote_addr,omitempty"`
+	Expect     string            `yaml:"expect"`
+}
+
+//Reads a file with policy tests: a configuration file, or directory, with only a tests section.
+//A title and files included with more tests are allowed too.
+func ReadTests(filename string) (*Config, error) {
+	conf, err := Readconfig(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(conf.Rules) > 0 {
+		return nil, errors.New(fmt.Sprintf("%s: a tests file must only contain tests", filename))
+	}
+	for key, p := range conf.defined {
+		if key != "title" && key != "include" {
+			return nil, errors.New(fmt.Sprintf("%s: a tests file must only contain tests, not %s", p, key))
+		}
+	}
+	return conf, nil
+}
+
+//Returns the request of the test
+func (t *PolicyTest) request() *Request {
+	method := t.Method
+	if method == "" {
+		method = "GET"
+	}
+
+	header := http.Header{}
+	for name, value := range t.Header {
+		header.Set(name, value)
+	}
+	return &Request{Subject: t.Subject, Method: method, Url: t.Url, Header: header, RemoteAddr: t.RemoteAddr}
+}
+
+//Runs the policy tests of conf against rb and returns a diagnostic for every test that fails,
+//in the order of the tests
+func (rb Rulebase) RunTests(conf *Config) []Diagnostic {
+	var failures []Diagnostic
+
+	for i, t := range conf.Tests {
+		at := conf.testorigin(i)
+		fail := func(format string, args ...interface{}) {
+			name := t.Name
+			if name == "" {
+				name = fmt.Sprintf("%s %s for %s", strings.ToUpper(t.request().Method), t.Url, t.Subject)
+			}
+			failures = append(failures, Diagnostic{at.file, at.line, at.column, name + ": " + fmt.Sprintf(format, args...)})
+		}
+
+		if t.Expect != "allow" && t.Expect != "deny" {
+			fail("expect must be allow or deny, not %q", t.Expect)
+			continue
+		}
+		d, err := rb.Explain(t.request())
+		if err != nil {
+			fail("%s", strings.TrimSpace(err.Error()))
+			continue
+		}
+
+		got := "deny"
+		if d.Allowed {
+			got = "allow"
+		}
+		if got != t.Expect {
+			rule := "no rule matched"
+			if d.Unreachable {
+				rule = "the client is outside the networks " + d.Url + " is restricted to"
+			} else if d.Rule != "" {
+				rule = "rule " + d.Rule
+			}
+			fail("expected %s, got %s (%s)", t.Expect, got, rule)
+		}
+	}
+
+	return failures
+}
+
+//Returns the position of the i-th policy test, if it is known
+func (c *Config) testorigin(i int) position {
+	if i < len(c.test_origins) {
+		return c.test_origins[i]
+	}
+	return position{}
+}
@@ -0,0 +1,140 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const policytest_test_conf = `---
+groups:
+  admins: [Jim]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      anonymous: [GET]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      admins: [GET, PUT]
+  - Url: www.corpA.com/admin/*
+    Conditions:
+      Headers:
+        X-Break-Glass: "1"
+    ACL:
+      John: [GET]
+
+tests:
+  - subject: Jim
+    method: PUT
+    url: www.corpA.com/admin/users
+    expect: allow
+  - name: John can't see the admin pages
+    subject: John
+    url: www.corpA.com/admin/users
+    expect: deny
+  - subject: John
+    url: www.corpA.com/admin/users
+    header:
+      X-Break-Glass: "1"
+    expect: allow
+  - subject: anonymous
+    method: post
+    url: www.corpA.com/
+    expect: allow`
+
+const policytest_test_file = `---
+tests:
+  - subject: Jim
+    method: DELETE
+    url: www.corpA.com/admin/users
+    expect: allow
+  - subject: John
+    url: www.corpA.com/admin/users
+    expect: allow
+  - subject: Jim
+    url: www.corpA.com/wiki
+    expect: allow`
+
+func TestRunTests(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": policytest_test_conf, "tests.yml": policytest_test_file})
+
+	conf, err := Readconfig(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := Build(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests, err := ReadTests(filepath.Join(dir, "tests.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"conf.yml:33:5: POST www.corpA.com/ for anonymous: expected allow, got deny (rule www.corpa.com/*)",
+		"tests.yml:3:5: DELETE www.corpA.com/admin/users for Jim: expected allow, got deny (rule www.corpa.com/admin/*)",
+		"tests.yml:7:5: GET www.corpA.com/admin/users for John: expected allow, got deny (rule www.corpa.com/admin/*)",
+		"tests.yml:10:5: GET www.corpA.com/wiki for Jim: expected allow, got deny (rule www.corpa.com/*)",
+	}
+
+	failures := append(rb.RunTests(conf), rb.RunTests(tests)...)
+	for i, f := range failures {
+		message := strings.TrimPrefix(f.String(), dir+string(filepath.Separator))
+		if i >= len(expected) || message != expected[i] {
+			t.Errorf("Unexpected failure %s", message)
+		}
+	}
+	if len(failures) != len(expected) {
+		t.Errorf("Got %d failures, expected %d", len(failures), len(expected))
+	}
+
+	_, err = ReadTests(filepath.Join(dir, "conf.yml"))
+	if err == nil {
+		t.Errorf("Reading a configuration with rules as a tests file should fail")
+	}
+}
+
+func TestReadTestsTitleInclude(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{
+		"all.yml":    "title: All tests\ninclude: [tests.yml]\ntests:\n  - subject: Jim\n    url: www.corpA.com/\n    expect: deny\n",
+		"tests.yml":  policytest_test_file,
+		"groups.yml": "groups:\n  admins: [Jim]\ntests:\n  - subject: Jim\n    url: www.corpA.com/\n    expect: deny\n",
+	})
+
+	tests, err := ReadTests(filepath.Join(dir, "all.yml"))
+	if err != nil {
+		t.Fatalf("Reading a tests file with a title and includes failed (%s)", err)
+	}
+	if len(tests.Tests) != 4 {
+		t.Errorf("Got %d tests, expected 4", len(tests.Tests))
+	}
+
+	_, err = ReadTests(filepath.Join(dir, "groups.yml"))
+	if err == nil {
+		t.Errorf("Reading a tests file with groups should fail")
+	}
+}
+
+func TestValidateTests(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"tests.yml": "tests:\n  - subject: Jim\n    method: FETCH\n    expect: maybe\n    colour: blue\n"})
+
+	expected := []string{
+		"tests.yml:2:5: test without url",
+		"tests.yml:3:13: unknown HTTP verb FETCH",
+		"tests.yml:4:13: expect must be allow or deny, not maybe",
+		"tests.yml:5:5: unknown key colour",
+	}
+
+	diagnostics := Validate(filepath.Join(dir, "tests.yml"))
+	for i, d := range diagnostics {
+		message := strings.TrimPrefix(d.String(), dir+string(filepath.Separator))
+		if i >= len(expected) || message != expected[i] {
+			t.Errorf("Unexpected diagnostic %s", message)
+		}
+	}
+	if len(diagnostics) != len(expected) {
+		t.Errorf("Got %d diagnostics, expected %d", len(diagnostics), len(expected))
+	}
+}
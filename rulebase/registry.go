@@ -0,0 +1,335 @@
+package rulebase
+
+import (
+	"authz/prefixtree"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Tenant describes a rulebase of a registry and the requests it is selected for. Config is the
+//configuration file of the rulebase, Hosts the hosts and Prefixes the URL prefixes (with an
+//optional trailing wildcard) of its requests. Candidate is the configuration file of a
+//rulebase that is evaluated in the shadow of the tenant's rulebase, if any.
+type Tenant struct {
+	Name      string   `yaml:"Name"`
+	Config    string   `yaml:"Config"`
+	Candidate string   `yaml:"Candidate,omitempty"`
+	Hosts     []string `yaml:"Hosts,omitempty"`
+	Prefixes  []string `yaml:"Prefixes,omitempty"`
+}
+
+//RegistryConfig mirrors the layout of a registry's YAML configuration file. Relative Config
+//and Candidate paths of tenants are relative to the directory of the registry file.
+type RegistryConfig struct {
+	TenantHeader string   `yaml:"tenant_header"`
+	Tenants      []Tenant `yaml:"tenants"`
+}
+
+//Registry serves several tenants from one deployment. Each tenant has a rulebase of its own, so
+//subjects and groups of different tenants never collide, and is reloaded on its own, so a bad
+//configuration only affects its tenant.
+//
+//A request is served by the tenant named in the tenant header, if one is set and the request
+//has it, otherwise by the tenant of its host or, failing that, of the longest prefix matching
+//its URL. The tenant header must only be set if the proxy in front of authz sets or strips it.
+type Registry struct {
+	lock     sync.RWMutex
+	header   string
+	tenants  map[string]*tenant
+	hosts    map[string]string
+	prefixes *prefixtree.Tree
+	prefix   map[string]string
+	log      func(string, *Disagreement)
+}
+
+type tenant struct {
+	Tenant
+	rb     *Rulebase
+	shadow *Shadow
+}
+
+//Creates a new empty registry. header is the name of the request header that names the tenant
+//of a request, or "" to select tenants by host and URL prefix only.
+func NewRegistry(header string) *Registry {
+	return &Registry{
+		header:   header,
+		tenants:  make(map[string]*tenant),
+		hosts:    make(map[string]string),
+		prefixes: prefixtree.New(),
+		prefix:   make(map[string]string),
+	}
+}
+
+//Adds a tenant to the registry and loads its rulebase. A tenant whose configuration fails to
+//load is added anyway and its requests are refused until a Reload succeeds.
+func (r *Registry) AddTenant(t Tenant) error {
+	err := r.add(t)
+	if err != nil {
+		return err
+	}
+	return r.Reload(t.Name)
+}
+
+//Adds a tenant without a rulebase to the registry
+func (r *Registry) add(t Tenant) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if t.Name == "" {
+		return errors.New("tenant without a name")
+	}
+	if _, exists := r.tenants[t.Name]; exists {
+		return errors.New(fmt.Sprintf("duplicate tenant %s", t.Name))
+	}
+
+	var hosts []string
+	for _, h := range t.Hosts {
+		host, err := canonicalhost(h)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid host %s of tenant %s (%s)", h, t.Name, err))
+		}
+		if other, exists := r.hosts[host]; exists {
+			return errors.New(fmt.Sprintf("host %s of tenant %s belongs to tenant %s", h, t.Name, other))
+		}
+		hosts = append(hosts, host)
+	}
+	var prefixes []string
+	for _, p := range t.Prefixes {
+		url, err := canonicalrule(p)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid prefix %s of tenant %s (%s)", p, t.Name, err))
+		}
+		if other, exists := r.prefix[url]; exists {
+			return errors.New(fmt.Sprintf("prefix %s of tenant %s belongs to tenant %s", p, t.Name, other))
+		}
+		prefixes = append(prefixes, url)
+	}
+
+	//the prefixes are inserted before anything is registered, so a failing insert leaves no part
+	//of the tenant behind; nodes of unregistered prefixes select no tenant
+	for _, url := range prefixes {
+		err := r.prefixes.AddKeys(url, nil)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid prefix %s of tenant %s (%s)", url, t.Name, err))
+		}
+	}
+	for _, host := range hosts {
+		r.hosts[host] = t.Name
+	}
+	for _, url := range prefixes {
+		r.prefix[url] = t.Name
+	}
+
+	r.tenants[t.Name] = &tenant{t, nil, nil}
+	return nil
+}
+
+//Loads the configuration of the tenant name again, and that of its candidate if it has one.
+//The tenant's rulebase is only replaced if both load, otherwise the tenant keeps serving its
+//last good rulebase.
+func (r *Registry) Reload(name string) error {
+	r.lock.RLock()
+	t, exists := r.tenants[name]
+	r.lock.RUnlock()
+	if !exists {
+		return errors.New(fmt.Sprintf("unknown tenant %s", name))
+	}
+
+	rb, err := Load(t.Config)
+	if err != nil {
+		return errors.New(fmt.Sprintf("tenant %s: %s: %s", name, t.Config, err))
+	}
+	var shadow *Shadow
+	if t.Candidate != "" {
+		candidate, err := Load(t.Candidate)
+		if err != nil {
+			return errors.New(fmt.Sprintf("tenant %s: %s: %s", name, t.Candidate, err))
+		}
+		shadow = NewShadow(rb, candidate, func(d *Disagreement) {
+			r.lock.RLock()
+			log := r.log
+			r.lock.RUnlock()
+			if log != nil {
+				log(name, d)
+			}
+		})
+	}
+
+	r.lock.Lock()
+	previous := t.shadow
+	t.rb, t.shadow = rb, shadow
+	r.lock.Unlock()
+	if previous != nil {
+		previous.Close()
+	}
+	return nil
+}
+
+//Sets the function the disagreements of the candidates of tenants are passed to, with the name
+//of the tenant. It is called from the goroutines that evaluate the candidates.
+func (r *Registry) SetShadowLog(log func(string, *Disagreement)) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.log = log
+}
+
+//Returns the shadow evaluation of the candidate of the tenant name, nil if the tenant has no
+//candidate or isn't loaded
+func (r *Registry) Shadow(name string) *Shadow {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	if t, exists := r.tenants[name]; exists {
+		return t.shadow
+	}
+	return nil
+}
+
+//Reloads every tenant and returns the errors of those that failed, by tenant name
+func (r *Registry) ReloadAll() map[string]error {
+	errs := make(map[string]error)
+	for _, name := range r.Tenants() {
+		if err := r.Reload(name); err != nil {
+			errs[name] = err
+		}
+	}
+	return errs
+}
+
+//Returns the names of the tenants in the registry, sorted
+func (r *Registry) Tenants() []string {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	names := make([]string, 0, len(r.tenants))
+	for name := range r.tenants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Returns the name and the rulebase of the tenant that serves req
+func (r *Registry) Select(req *Request) (string, *Rulebase, error) {
+	name, rb, _, err := r.lookup(req)
+	return name, rb, err
+}
+
+//Returns the name, the rulebase and the shadow evaluation of the tenant that serves req. They
+//are taken under one lock, so a concurrent Reload can't pair the rulebase of one configuration
+//with the candidate of another.
+func (r *Registry) lookup(req *Request) (string, *Rulebase, *Shadow, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	name, err := r.selecttenant(req)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	t, exists := r.tenants[name]
+	if !exists {
+		return "", nil, nil, errors.New(fmt.Sprintf("unknown tenant %s", name))
+	}
+	if t.rb == nil {
+		return name, nil, nil, errors.New(fmt.Sprintf("tenant %s has no valid configuration", name))
+	}
+	return name, t.rb, t.shadow, nil
+}
+
+func (r *Registry) selecttenant(req *Request) (string, error) {
+	if r.header != "" {
+		if name := req.Header.Get(r.header); name != "" {
+			return name, nil
+		}
+	}
+
+	url, err := Canonicalize(req.Url, false)
+	if err != nil {
+		return "", err
+	}
+	host := url
+	if i := strings.IndexByte(url, '/'); i >= 0 {
+		host = url[:i]
+	}
+	if name, exists := r.hosts[host]; exists {
+		return name, nil
+	}
+
+	for _, m := range r.prefixes.MatchAll(url) {
+		url := m.Path
+		if m.Wildcard {
+			url += "*"
+		}
+		if name, exists := r.prefix[url]; exists {
+			return name, nil
+		}
+	}
+
+	return "", errors.New(fmt.Sprintf("no tenant for %s", req.Url))
+}
+
+//Authorizes req with the rulebase of its tenant, and evaluates it with the tenant's candidate
+//if it has one. Requests without a tenant, or for a tenant without a valid configuration, get
+//no access.
+func (r *Registry) Authorize(req *Request) (int, error) {
+	_, rb, shadow, err := r.lookup(req)
+	if err != nil {
+		return 0, err
+	}
+	if shadow != nil {
+		return shadow.Authorize(req)
+	}
+	return rb.Authorize(req)
+}
+
+//Reads the registry configuration file filename and loads the rulebases of its tenants. If some
+//tenants fail to load the registry is returned together with an error listing them, the other
+//tenants can serve requests.
+func LoadRegistry(filename string) (*Registry, error) {
+	var conf RegistryConfig
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	root, err := parsedocument(filename, data)
+	if err != nil {
+		return nil, err
+	}
+	err = root.Decode(&conf)
+	if err != nil {
+		return nil, err
+	}
+
+	r := NewRegistry(conf.TenantHeader)
+	for _, t := range conf.Tenants {
+		if t.Config != "" && !filepath.IsAbs(t.Config) {
+			t.Config = filepath.Join(filepath.Dir(filename), t.Config)
+		}
+		if t.Candidate != "" && !filepath.IsAbs(t.Candidate) {
+			t.Candidate = filepath.Join(filepath.Dir(filename), t.Candidate)
+		}
+		err = r.add(t)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var failed []string
+	for _, t := range conf.Tenants {
+		if err := r.Reload(t.Name); err != nil {
+			failed = append(failed, err.Error())
+		}
+	}
+
+	if len(failed) > 0 {
+		return r, errors.New(strings.Join(failed, "\n"))
+	}
+	return r, nil
+}
@@ -0,0 +1,211 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+const registry_test_conf = `---
+tenant_header: X-Authz-Tenant
+
+tenants:
+  - Name: acme
+    Config: acme.yml
+    Hosts: [www.acme.com]
+    Prefixes: [shared.example.com/acme/*]
+  - Name: globex
+    Config: globex.yml
+    Hosts: [WWW.GLOBEX.COM:443]
+    Prefixes: [shared.example.com/*]`
+
+const acme_test_conf = `---
+groups:
+  admins: [Jim]
+
+rules:
+  - Url: www.acme.com/*
+    ACL:
+      admins: [GET, PUT]
+  - Url: shared.example.com/acme/*
+    ACL:
+      Jim: [GET]`
+
+const globex_test_conf = `---
+rules:
+  - Url: www.globex.com/*
+    ACL:
+      Jim: [GET]
+  - Url: shared.example.com/*
+    ACL:
+      Jim: [DELETE]`
+
+//Writes the files to a temporary directory and returns its path
+func writetestfiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestRegistry(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"tenants.yml": registry_test_conf, "acme.yml": acme_test_conf, "globex.yml": globex_test_conf})
+	r, err := LoadRegistry(filepath.Join(dir, "tenants.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	globex_header := http.Header{}
+	globex_header.Set("X-Authz-Tenant", "globex")
+
+	cases := []struct {
+		url    string
+		header http.Header
+		tenant string
+		access int
+	}{
+		//groups of a tenant only exist in its rulebase
+		{"www.acme.com/index.html", nil, "acme", GET | PUT},
+		{"www.acme.com/index.html", globex_header, "globex", 0},
+		{"www.globex.com/index.html", nil, "globex", GET},
+		{"shared.example.com/acme/x", nil, "acme", GET},
+		{"shared.example.com/globex/x", nil, "globex", DELETE},
+	}
+
+	for _, c := range cases {
+		req := &Request{Subject: "Jim", Url: c.url, Header: c.header}
+		name, _, err := r.Select(req)
+		if err != nil || name != c.tenant {
+			t.Errorf("Wrong tenant %s (%v) for %s, should be %s", name, err, c.url, c.tenant)
+			continue
+		}
+		access, err := r.Authorize(req)
+		if err != nil {
+			t.Errorf("Authorize %s failed (%s)", c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s, should be %d", access, c.url, c.access)
+		}
+	}
+
+	_, err = r.Authorize(&Request{Subject: "Jim", Url: "www.initech.com/"})
+	if err == nil {
+		t.Errorf("Authorizing a request without a tenant should fail")
+	}
+}
+
+func TestRegistryReload(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"tenants.yml": registry_test_conf, "acme.yml": acme_test_conf, "globex.yml": "rules: [broken"})
+	r, err := LoadRegistry(filepath.Join(dir, "tenants.yml"))
+	if err == nil {
+		t.Fatalf("Loading a registry with a broken tenant should report it")
+	}
+
+	//a broken tenant doesn't affect the others
+	access, err := r.Authorize(&Request{Subject: "Jim", Url: "www.acme.com/"})
+	if err != nil || access != GET|PUT {
+		t.Errorf("Wrong authorization value %d (%v) for tenant acme, should be %d", access, err, GET|PUT)
+	}
+	_, err = r.Authorize(&Request{Subject: "Jim", Url: "www.globex.com/"})
+	if err == nil {
+		t.Errorf("Authorizing a request for a broken tenant should fail")
+	}
+
+	//a failed reload keeps the last good rulebase
+	err = ioutil.WriteFile(filepath.Join(dir, "acme.yml"), []byte("rules: [broken"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	errs := r.ReloadAll()
+	if len(errs) != 2 {
+		t.Errorf("Reloading should fail for both tenants, got %v", errs)
+	}
+	access, err = r.Authorize(&Request{Subject: "Jim", Url: "www.acme.com/"})
+	if err != nil || access != GET|PUT {
+		t.Errorf("Wrong authorization value %d (%v) for tenant acme after a failed reload, should be %d", access, err, GET|PUT)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "globex.yml"), []byte(globex_test_conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Reload("globex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, err = r.Authorize(&Request{Subject: "Jim", Url: "www.globex.com/"})
+	if err != nil || access != GET {
+		t.Errorf("Wrong authorization value %d (%v) for tenant globex after reload, should be %d", access, err, GET)
+	}
+}
+
+func TestRegistryShadow(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{
+		"tenants.yml":   "tenants:\n  - Name: acme\n    Config: acme.yml\n    Candidate: candidate.yml\n    Hosts: [www.acme.com]\n",
+		"acme.yml":      acme_test_conf,
+		"candidate.yml": "rules:\n  - Url: www.acme.com/*\n    ACL:\n      Jim: [GET]\n",
+	})
+	r, err := LoadRegistry(filepath.Join(dir, "tenants.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tenants []string
+	r.SetShadowLog(func(name string, d *Disagreement) {
+		tenants = append(tenants, name)
+	})
+
+	//the candidate is evaluated but the tenant's rulebase decides
+	access, err := r.Authorize(&Request{Subject: "Jim", Method: "PUT", Url: "www.acme.com/"})
+	if err != nil || access != GET|PUT {
+		t.Errorf("Wrong authorization value %d (%v) for tenant acme, should be %d", access, err, GET|PUT)
+	}
+
+	shadow := r.Shadow("acme")
+	if shadow == nil {
+		t.Fatalf("Tenant acme has no shadow evaluation of its candidate")
+	}
+	shadow.Close()
+	if requests, disagreements := shadow.Stats(); requests != 1 || disagreements != 1 {
+		t.Errorf("Got %d requests and %d disagreements, expected 1 and 1", requests, disagreements)
+	}
+	if len(tenants) != 1 || tenants[0] != "acme" {
+		t.Errorf("Disagreements logged for tenants %v, expected [acme]", tenants)
+	}
+}
+
+func TestRegistryConflicts(t *testing.T) {
+	r := NewRegistry("")
+	err := r.add(Tenant{Name: "acme", Hosts: []string{"www.acme.com"}, Prefixes: []string{"shared.example.com/acme/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tenant := range []Tenant{
+		{Name: "acme"},
+		{Name: ""},
+		{Name: "globex", Hosts: []string{"WWW.ACME.COM"}},
+		{Name: "globex", Prefixes: []string{"shared.example.com/acme/*"}},
+	} {
+		err := r.add(tenant)
+		if err == nil {
+			t.Errorf("Adding tenant %+v should fail", tenant)
+		}
+	}
+
+	//a tenant that fails to be added leaves none of its hosts and prefixes registered
+	err = r.add(Tenant{Name: "globex", Hosts: []string{"www.globex.com"}, Prefixes: []string{"shared.example.com/globex/*", "www.globex*.com/*"}})
+	if err == nil {
+		t.Errorf("Adding a tenant with an invalid prefix should fail")
+	}
+	err = r.add(Tenant{Name: "initech", Hosts: []string{"www.globex.com"}, Prefixes: []string{"shared.example.com/globex/*"}})
+	if err != nil {
+		t.Errorf("Hosts and prefixes of a tenant that failed to be added must stay free (%s)", err)
+	}
+}
@@ -0,0 +1,161 @@
+package rulebase
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//LoggedRequest is a request read from an access log or a log of decisions together with the
+//decision it got at the time
+type LoggedRequest struct {
+	Request
+	Allowed bool
+}
+
+//NGINX's combined log format:
+//$remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+var combined = regexp.MustCompile(`^(\S+) \S+ (\S+) \[[^\]]*\] "(\S+) (\S+)[^"]*" (\d{3}) `)
+
+//Parses a line of an NGINX access log in the combined format. The subject is the remote user,
+//anonymous if there is none, and requests answered with 401 or 403 count as denied, all others
+//as allowed. The log doesn't record the host, so request targets that aren't absolute URLs are
+//taken to be on host.
+func ParseAccessLogLine(line string, host string) (*LoggedRequest, error) {
+	m := combined.FindStringSubmatch(line)
+	if m == nil {
+		return nil, errors.New("not in the combined log format")
+	}
+	status, _ := strconv.Atoi(m[5])
+
+	r := LoggedRequest{Request: Request{Subject: m[2], Method: m[3], Url: m[4], RemoteAddr: m[1]}}
+	if r.Subject == "-" {
+		r.Subject = "anonymous"
+	}
+	if !strings.Contains(r.Url, "://") {
+		if host == "" {
+			return nil, errors.New(fmt.Sprintf("no host for %s", r.Url))
+		}
+		r.Url = host + r.Url
+	}
+	r.Allowed = status != 401 && status != 403
+
+	return &r, nil
+}
+
+//A line of a log of decisions
+type loggeddecision struct {
+	Subject    string            `json:"subject"`
+	Method     string            `json:"method"`
+	Url        string            `json:"url"`
+	Header     map[string]string `json:"header"`
+	RemoteAddr string            `json:"remote_addr"`
+	Decision   string            `json:"decision"`
+}
+
+//Parses a line of a log of past decisions, a JSON object with the fields subject, method, url,
+//header, remote_addr and decision, which is allow or deny
+func ParseDecisionLine(line string) (*LoggedRequest, error) {
+	var l loggeddecision
+
+	err := json.Unmarshal([]byte(line), &l)
+	if err != nil {
+		return nil, err
+	}
+	if l.Decision != "allow" && l.Decision != "deny" {
+		return nil, errors.New(fmt.Sprintf("decision must be allow or deny, not %q", l.Decision))
+	}
+
+	t := PolicyTest{Subject: l.Subject, Method: l.Method, Url: l.Url, Header: l.Header, RemoteAddr: l.RemoteAddr}
+	return &LoggedRequest{Request: *t.request(), Allowed: l.Decision == "allow"}, nil
+}
+
+//Divergence counts the replayed requests of a subject whose decision changed below a URL prefix:
+//the longest prefix of a rule of the candidate rulebase that matched them, or their host if none
+//did
+type Divergence struct {
+	Subject string
+	Prefix  string
+	Granted int            //requests denied before that the candidate allows
+	Revoked int            //requests allowed before that the candidate denies
+	Example *LoggedRequest //the first request whose decision changed
+}
+
+//Replay evaluates logged requests against a candidate rulebase and collects the requests whose
+//decision would change
+type Replay struct {
+	rb          *Rulebase
+	Requests    int //requests replayed
+	Errors      int //requests the candidate couldn't evaluate, e.g. because of an invalid URL
+	Unsupported int //requests with a method rules have no verb for, e.g. HEAD, OPTIONS or PATCH
+	divergences map[[2]string]*Divergence
+}
+
+//Creates a replay against the candidate rulebase rb
+func NewReplay(rb *Rulebase) *Replay {
+	return &Replay{rb: rb, divergences: make(map[[2]string]*Divergence)}
+}
+
+//Evaluates a logged request against the candidate and records it if its decision changes
+func (r *Replay) Add(req *LoggedRequest) {
+	r.Requests++
+
+	//the rulebase can't decide on methods it has no verb for, they aren't errors of the candidate
+	if _, err := accessflags([]string{strings.ToUpper(req.Method)}); err != nil {
+		r.Unsupported++
+		return
+	}
+
+	d, err := r.rb.Explain(&req.Request)
+	if err != nil {
+		r.Errors++
+		return
+	}
+	if d.Allowed == req.Allowed {
+		return
+	}
+
+	prefix := r.prefix(d.Url)
+	key := [2]string{req.Subject, prefix}
+	div, exists := r.divergences[key]
+	if !exists {
+		div = &Divergence{Subject: req.Subject, Prefix: prefix, Example: req}
+		r.divergences[key] = div
+	}
+	if d.Allowed {
+		div.Granted++
+	} else {
+		div.Revoked++
+	}
+}
+
+//Returns the prefix the changed decisions for the canonical URL url are grouped by, see Divergence
+func (r *Replay) prefix(url string) string {
+	if m := r.rb.tree.MatchAll(url); len(m) > 0 {
+		return m[0].Path
+	}
+	if i := strings.IndexByte(url, '/'); i >= 0 {
+		return url[:i+1]
+	}
+	return url
+}
+
+//Returns the changed decisions grouped by subject and URL prefix, sorted by subject and prefix
+func (r *Replay) Divergences() []Divergence {
+	divergences := make([]Divergence, 0, len(r.divergences))
+	for _, div := range r.divergences {
+		divergences = append(divergences, *div)
+	}
+
+	sort.Slice(divergences, func(i, j int) bool {
+		if divergences[i].Subject != divergences[j].Subject {
+			return divergences[i].Subject < divergences[j].Subject
+		}
+		return divergences[i].Prefix < divergences[j].Prefix
+	})
+	return divergences
+}
@@ -0,0 +1,103 @@
+package rulebase
+
+import (
+	"testing"
+)
+
+func TestParseAccessLogLine(t *testing.T) {
+	line := `10.0.0.1 - Jim [19/Oct/2026:10:00:00 +0000] "PUT /admin/users?id=1 HTTP/1.1" 403 153 "-" "curl/8.0"`
+	r, err := ParseAccessLogLine(line, "www.corpA.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "Jim" || r.Method != "PUT" || r.Url != "www.corpA.com/admin/users?id=1" || r.RemoteAddr != "10.0.0.1" || r.Allowed {
+		t.Errorf("Wrong request %+v for %s", r, line)
+	}
+
+	line = `10.0.0.2 - - [19/Oct/2026:10:00:01 +0000] "GET http://www.corpB.com/ HTTP/1.1" 200 512 "-" "curl/8.0"`
+	r, err = ParseAccessLogLine(line, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "anonymous" || r.Url != "http://www.corpB.com/" || !r.Allowed {
+		t.Errorf("Wrong request %+v for %s", r, line)
+	}
+
+	for _, line := range []string{
+		`10.0.0.1 - Jim [19/Oct/2026:10:00:00 +0000] "GET /admin HTTP/1.1" 200 153 "-" "curl/8.0"`,
+		`not a log line`,
+	} {
+		if _, err := ParseAccessLogLine(line, ""); err == nil {
+			t.Errorf("Parsing %s without a host should fail", line)
+		}
+	}
+}
+
+func TestParseDecisionLine(t *testing.T) {
+	r, err := ParseDecisionLine(`{"subject": "John", "method": "get", "url": "www.corpA.com/beta", "header": {"x-beta": "1"}, "decision": "deny"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Subject != "John" || r.Method != "get" || r.Header.Get("X-Beta") != "1" || r.Allowed {
+		t.Errorf("Wrong request %+v", r)
+	}
+
+	if _, err := ParseDecisionLine(`{"subject": "John", "url": "www.corpA.com/", "decision": "maybe"}`); err == nil {
+		t.Errorf("A decision other than allow or deny should fail")
+	}
+}
+
+func TestReplay(t *testing.T) {
+	rb := New()
+	rb.AddGroup("admins", []string{"Jim"})
+	for _, r := range []Rule{
+		{Url: "www.corpA.com/*", ACL: map[string][]string{"Jim": {"GET"}, "John": {"GET"}}},
+		{Url: "www.corpA.com/admin/*", ACL: map[string][]string{"admins": {"GET", "PUT"}}},
+	} {
+		if err := rb.Add(&r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	replay := NewReplay(rb)
+	for _, r := range []LoggedRequest{
+		{Request{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/users"}, false},
+		{Request{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/groups"}, false},
+		{Request{Subject: "Jim", Method: "GET", Url: "www.corpA.com/wiki"}, true},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpA.com/admin/users"}, true},
+		{Request{Subject: "John", Method: "POST", Url: "www.corpA.com/wiki"}, true},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpA.com/wiki"}, true},
+		{Request{Subject: "John", Method: "HEAD", Url: "www.corpA.com/wiki"}, true},
+		{Request{Subject: "John", Method: "OPTIONS", Url: "www.corpA.com/admin/users"}, true},
+		{Request{Subject: "John", Method: "GET", Url: "/wiki"}, true},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpB.com/"}, true},
+		{Request{Subject: "John", Method: "GET", Url: "www.corpB.com/shop/cart"}, true},
+	} {
+		r := r
+		replay.Add(&r)
+	}
+
+	expected := []Divergence{
+		{"Jim", "www.corpa.com/admin/", 2, 0, nil},
+		{"John", "www.corpa.com/", 0, 1, nil},
+		{"John", "www.corpa.com/admin/", 0, 1, nil},
+		{"John", "www.corpb.com/", 0, 2, nil},
+	}
+
+	divergences := replay.Divergences()
+	for i, d := range divergences {
+		if d.Example == nil {
+			t.Errorf("No example for %+v", d)
+		}
+		d.Example = nil
+		if i >= len(expected) || d != expected[i] {
+			t.Errorf("Unexpected divergence %+v", d)
+		}
+	}
+	if len(divergences) != len(expected) {
+		t.Errorf("Got %d divergences, expected %d", len(divergences), len(expected))
+	}
+	if replay.Requests != 11 || replay.Errors != 1 || replay.Unsupported != 2 {
+		t.Errorf("Replayed %d requests with %d errors and %d unsupported methods, expected 11 with 1 and 2", replay.Requests, replay.Errors, replay.Unsupported)
+	}
+}
@@ -0,0 +1,86 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//Role is a named set of permissions. It maps URL paths, relative to the scope a role is bound
+//at, to the HTTP verbs the role grants on them. "" stands for the scope itself and "*" for
+//everything below it.
+type Role map[string][]string
+
+//RoleBinding grants a role to subjects and groups at one or more scopes. A scope is a URL like
+//the ones used in rules, without a trailing wildcard.
+type RoleBinding struct {
+	Role     string   `yaml:"Role"`
+	Subjects []string `yaml:"Subjects"`
+	Scopes   []string `yaml:"Scopes"`
+}
+
+//Joins a role path to the scope it is bound at
+func scopeurl(scope string, path string) string {
+	if path == "" {
+		return scope
+	}
+	return strings.TrimSuffix(scope, "/") + "/" + strings.TrimPrefix(path, "/")
+}
+
+//Compiles role bindings into the rulebase. Each binding is expanded into an ACL entry for every
+//path of its role at every scope it is bound at. The entries are stored in the tree like the
+//ones of rules, so looking up access granted through a role costs the same as any other
+//lookup. Access a subject has on a URL through roles is added to the access it already has
+//there. Where no rule exists for the URL, the role entries are granted on top of the ACL of the
+//wildcard rule above it, which is looked up when the URL is, see ruleacl.
+func (rb Rulebase) AddRoles(roles map[string]Role, bindings []RoleBinding) error {
+	for _, b := range bindings {
+		role, exists := roles[b.Role]
+		if !exists {
+			return errors.New(fmt.Sprintf("Unknown role %s", b.Role))
+		}
+		if len(b.Scopes) == 0 {
+			return errors.New(fmt.Sprintf("Binding of role %s has no scopes", b.Role))
+		}
+
+		for _, scope := range b.Scopes {
+			if strings.HasSuffix(scope, "*") {
+				return errors.New(fmt.Sprintf("Scope %s of role %s must not end in a wildcard", scope, b.Role))
+			}
+			for path, access := range role {
+				access_flags, err := accessflags(access)
+				if err != nil {
+					return errors.New(fmt.Sprintf("Role %s: %s", b.Role, err))
+				}
+				for _, subject := range b.Subjects {
+					err = rb.grant(scopeurl(scope, path), subject, access_flags)
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+//Adds access_flags to the access subject has on url
+func (rb Rulebase) grant(url string, subject string, access_flags int) error {
+	url, err := canonicalrule(url)
+	if err != nil {
+		return err
+	}
+	path := strings.TrimSuffix(url, "*")
+
+	//"x/*" and "x/" share their node in the tree, so do their ACLs
+	if existing, err := rb.tree.Get(path, subject); err == nil {
+		access_flags |= existing
+	}
+
+	//a node without a rule of its own holds role entries only, see ruleacl
+	if m := rb.tree.MatchAll(path); len(m) == 0 || m[0].Path != path {
+		rb.placeholders[path] = true
+	}
+	return rb.tree.AddKey(url, subject, access_flags)
+}
@@ -0,0 +1,161 @@
+package rulebase
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+const roles_test_conf = `---
+title: "Rulebase with roles"
+
+groups:
+  developers: [John, Jack]
+
+roles:
+  reader:
+    "*": [GET]
+  editor:
+    "*": [GET, PUT]
+    "drafts/*": [GET, PUT, POST, DELETE]
+
+role_bindings:
+  - Role: reader
+    Subjects: [anonymous]
+    Scopes: [www.corpA.com/wiki, www.corpA.com/docs]
+  - Role: editor
+    Subjects: [developers]
+    Scopes: [www.corpA.com/wiki]
+
+rules:
+  - Url: www.corpA.com/wiki/*
+    ACL:
+      Jack: [POST]
+  - Url: www.corpA.com/wiki/drafts/secret
+    ACL:
+      Jim: [GET]`
+
+func TestAddRoles(t *testing.T) {
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
+	err := ioutil.WriteFile(config_filename, []byte(roles_test_conf), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := Load(config_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		subject, url string
+		access       int
+	}{
+		{"anonymous", "www.corpA.com/wiki/Home", GET},
+		{"anonymous", "www.corpA.com/docs/manual", GET},
+		//the editor bindings below the reader binding don't hide it
+		{"anonymous", "www.corpA.com/wiki/drafts/x", GET},
+		{"John", "www.corpA.com/wiki/Home", GET | PUT},
+		{"John", "www.corpA.com/wiki/drafts/x", GET | PUT | POST | DELETE},
+		{"John", "www.corpA.com/docs/manual", 0},
+		//role grants are added to those of rules for the same URL
+		{"Jack", "www.corpA.com/wiki/Home", GET | PUT | POST},
+		{"Jim", "www.corpA.com/wiki/drafts/secret", GET},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s, should be %d", access, c.subject, c.url, c.access)
+		}
+	}
+}
+
+//A binding below a wildcard rule must not take away what the rule grants others under its scope
+func TestAddRolesKeepInheritedACL(t *testing.T) {
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
+	err := ioutil.WriteFile(config_filename, []byte(`---
+roles:
+  editor:
+    "*": [GET, PUT]
+    "drafts/*": [GET, PUT, POST]
+
+role_bindings:
+  - Role: editor
+    Subjects: [Jack]
+    Scopes: [www.corpA.com/wiki]
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      Jim: [GET]
+      Jack: [GET]`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rb, err := Load(config_filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		subject, url string
+		access       int
+	}{
+		{"Jim", "www.corpA.com/wiki/Home", GET},
+		{"Jim", "www.corpA.com/wiki/drafts/x", GET},
+		{"Jack", "www.corpA.com/wiki/Home", GET | PUT},
+		{"Jack", "www.corpA.com/wiki/drafts/x", GET | PUT | POST},
+		{"Jack", "www.corpA.com/docs/manual", GET},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s, should be %d", access, c.subject, c.url, c.access)
+		}
+	}
+
+	//rules added above the role bindings afterwards are inherited as well
+	err = rb.Add(&Rule{Url: "www.corpA.com/*", ACL: map[string][]string{"Joe": {"GET", "POST"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases = []struct {
+		subject, url string
+		access       int
+	}{
+		{"Joe", "www.corpA.com/wiki/Home", GET | POST},
+		{"Joe", "www.corpA.com/wiki/drafts/x", GET | POST},
+		{"Jim", "www.corpA.com/wiki/Home", GET},
+		{"Jack", "www.corpA.com/wiki/Home", GET | PUT},
+	}
+
+	for _, c := range cases {
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s, should be %d", access, c.subject, c.url, c.access)
+		}
+	}
+}
+
+func TestAddRolesInvalid(t *testing.T) {
+	roles := map[string]Role{"reader": {"*": {"GET"}}, "broken": {"*": {"READ"}}}
+
+	for _, b := range []RoleBinding{
+		{Role: "writer", Subjects: []string{"Jim"}, Scopes: []string{"www.corpA.com/wiki"}},
+		{Role: "broken", Subjects: []string{"Jim"}, Scopes: []string{"www.corpA.com/wiki"}},
+		{Role: "reader", Subjects: []string{"Jim"}},
+		{Role: "reader", Subjects: []string{"Jim"}, Scopes: []string{"www.corpA.com/wiki/*"}},
+	} {
+		err := New().AddRoles(roles, []RoleBinding{b})
+		if err == nil {
+			t.Errorf("Adding role binding %+v should fail", b)
+		}
+	}
+}
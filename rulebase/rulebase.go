@@ -4,6 +4,10 @@ import (
 	"authz/prefixtree"
 	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
 )
 
 const (
@@ -11,6 +15,12 @@ const (
 	ALLOW
 )
 
+//Inheritance modes, see SetInheritance
+const (
+	OVERRIDE = "override"
+	INHERIT  = "inherit"
+)
+
 const (
 	GET    = 1
 	PUT    = 2
@@ -21,47 +31,112 @@ const (
 
 type Rulebase struct {
 	tree                 *prefixtree.Tree
+	conditional          map[string][]conditional
+	timed                map[string][]timed
+	gates                *prefixtree.Tree
+	placeholders         map[string]bool //paths whose node has no rule, only conditional and timed rules or role entries
+	networks             map[string][]*network
+	trusted              []*net.IPNet
+	attributes           map[string]map[string]string
 	group                map[string][]string
 	Groups               []string
 	default_access_flags int
+	defaults             *prefixtree.Tree
+	unknown_host_flags   *int
+	inherit              bool
+	now                  func() time.Time
 }
 
 type Rule struct {
-	Url string
-	ACL map[string][]string
+	Url        string              `yaml:"Url"`
+	Conditions *Conditions         `yaml:"Conditions,omitempty"`
+	Window     *Window             `yaml:"Window,omitempty"`
+	Network    *Network            `yaml:"Network,omitempty"`
+	Expression string              `yaml:"Expression,omitempty"`
+	Captures   string              `yaml:"Captures,omitempty"`
+	ACL        map[string][]string `yaml:"ACL,omitempty"`
+}
+
+//Request describes a client request to authorize. Url is the host and URI of the request
+//including its query string, Header the request headers and RemoteAddr the address of the peer
+//that sent the request (see SetTrustedProxies). Method and Attributes are only used by rule
+//expressions; Attributes are attributes of the subject known to the caller, e.g. claims of a
+//token, and take precedence over the ones configured with SetAttributes.
+type Request struct {
+	Subject    string
+	Method     string
+	Url        string
+	Header     http.Header
+	RemoteAddr string
+	Attributes map[string]string
 }
 
 //Creates a new empty rulebase
 func New() *Rulebase {
 	var rb Rulebase
 	rb.tree = prefixtree.New()
+	rb.conditional = make(map[string][]conditional)
+	rb.timed = make(map[string][]timed)
+	rb.gates = prefixtree.New()
+	rb.placeholders = make(map[string]bool)
+	rb.networks = make(map[string][]*network)
+	rb.attributes = make(map[string]map[string]string)
+	rb.defaults = prefixtree.New()
+	rb.now = time.Now
 	rb.SetDefaultAccess([]string{})
 	rb.group = make(map[string][]string)
 	return &rb
 }
 
 //Sets the access flags for the default access policy. Expects and array of HTTP verbs as strings
-func (rb Rulebase) SetDefaultAccess(access []string) error {
+//Default policies for hosts and prefixes (see AddDefaultAccess) take precedence over this one.
+func (rb *Rulebase) SetDefaultAccess(access []string) error {
+	access_flags, err := accessflags(access)
+	if err != nil {
+		return err
+	}
+
+	rb.default_access_flags = access_flags
+	return nil
+}
+
+//Sets how the ACLs of nested prefixes combine. With OVERRIDE (the default) the ACL of the most
+//specific prefix matching a URL is the only one that counts. With INHERIT a subject that isn't
+//mentioned in that ACL, neither itself nor through one of its groups, gets the access it has on
+//the nearest wildcard prefix above it whose ACL mentions it. An empty list of verbs mentions a
+//subject too, so it can be used to stop inheritance.
+func (rb *Rulebase) SetInheritance(mode string) error {
+	switch mode {
+	case OVERRIDE, "":
+		rb.inherit = false
+	case INHERIT:
+		rb.inherit = true
+	default:
+		return errors.New(fmt.Sprintf("Unknown inheritance mode %s", mode))
+	}
+	return nil
+}
+
+//Converts a list of HTTP verbs to access flags
+func accessflags(access []string) (int, error) {
 	access_flags := 0
 	for _, v := range access {
 		switch v {
 		case "GET":
-			access_flags += GET
+			access_flags |= GET
 		case "PUT":
-			access_flags += PUT
+			access_flags |= PUT
 		case "POST":
-			access_flags += POST
+			access_flags |= POST
 		case "DELETE":
-			access_flags += DELETE
+			access_flags |= DELETE
 		case "UPDATE":
-			access_flags += UPDATE
+			access_flags |= UPDATE
 		default:
-			return errors.New(fmt.Sprintf("Unknown HTTP verb %s\n", v))
+			return 0, errors.New(fmt.Sprintf("Unknown HTTP verb %s\n", v))
 		}
 	}
-
-	rb.default_access_flags = access_flags
-	return nil
+	return access_flags, nil
 }
 
 //Creates a new rulebase from an array of rules
@@ -78,7 +153,7 @@ func Create(rules *[]Rule) (*Rulebase, error) {
 	return rb, nil
 }
 
-func (rb Rulebase) AddGroups(groups map[string][]string) {
+func (rb *Rulebase) AddGroups(groups map[string][]string) {
 	//Setup the groups map
 	if groups != nil {
 		for group, subjects := range groups {
@@ -91,7 +166,7 @@ func (rb Rulebase) AddGroups(groups map[string][]string) {
 	// }
 }
 
-func (rb Rulebase) AddGroup(group string, subjects []string) {
+func (rb *Rulebase) AddGroup(group string, subjects []string) {
 	if group != "" {
 		rb.Groups = append(rb.Groups, group)
 		for _, subject := range subjects {
@@ -108,33 +183,100 @@ func (rb Rulebase) ModGroup(group string, subjects []string) {
 func (rb Rulebase) DelGroup(group string, subjects []string) {
 }
 
-//Adds a rule to a rulebase
+//Adds a rule to a rulebase. The rule's URL is canonicalized before it is inserted.
+//
+//A rule with conditions doesn't change the ACL stored in the tree for its URL. Instead its ACL
+//replaces the stored one for requests that satisfy the conditions. If several conditional
+//rules for the same URL are satisfied the one added first wins.
+//
+//A rule with a window or an expression but no conditions adds its ACL to the stored one while
+//the window is open and the expression holds. The expression of a conditional rule is one more
+//condition. Expressions are only evaluated for rules of the prefix the tree matched.
+//
+//The network restrictions of a rule apply to every request for its URL, whichever rule grants
+//the access. A rule with network restrictions and an empty ACL only adds the restrictions.
 func (rb Rulebase) Add(r *Rule) error {
+	var w *window
+	var e *expression
+	var captures []string
+	url, err := canonicalrule(r.Url)
+	if err != nil {
+		return err
+	}
+
+	keys := make(map[string]int)
 	for subject, access := range r.ACL {
 		// fmt.Printf("user: %s -> url: %s access(%T): %s\n", subject, r.Url, access, access)
-		access_flags := 0
-		for _, v := range access {
-			switch v {
-			case "GET":
-				access_flags += GET
-			case "PUT":
-				access_flags += PUT
-			case "POST":
-				access_flags += POST
-			case "DELETE":
-				access_flags += DELETE
-			case "UPDATE":
-				access_flags += UPDATE
-			default:
-				return errors.New(fmt.Sprintf("Unknown HTTP verb %s\n", v))
-			}
+		access_flags, err := accessflags(access)
+		if err != nil {
+			return err
 		}
+		keys[subject] = access_flags
+	}
 
-		// fmt.Printf("user: %s -> url: %s access: %s\n", subject, r.Url, access_flags)
-		err := rb.tree.AddKey(r.Url, subject, access_flags)
+	if r.Network != nil {
+		nw, err := r.Network.parse(strings.HasSuffix(url, "*"))
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid network for %s (%s)", r.Url, err))
+		}
+		err = rb.gates.AddKeys(url, nil)
 		if err != nil {
 			return err
 		}
+		path := strings.TrimSuffix(url, "*")
+		rb.networks[path] = append(rb.networks[path], nw)
+		if len(r.ACL) == 0 {
+			return nil
+		}
+	}
+
+	if r.Window != nil {
+		w, err = r.Window.parse()
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid window for %s (%s)", r.Url, err))
+		}
+	}
+
+	if r.Expression != "" {
+		e, err = compileexpression(r.Expression)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid expression for %s (%s)", r.Url, err))
+		}
+	}
+	if r.Captures != "" {
+		if e == nil {
+			return errors.New(fmt.Sprintf("captures for %s without an expression", r.Url))
+		}
+		captures, err = parsecaptures(r.Captures)
+		if err != nil {
+			return errors.New(fmt.Sprintf("invalid captures for %s (%s)", r.Url, err))
+		}
+	}
+
+	if r.Conditions != nil || w != nil || e != nil {
+		//make sure the URL has a node in the tree even if there is no unconditional rule for it.
+		//Such a node has no ACL of its own, see ruleacl.
+		path := strings.TrimSuffix(url, "*")
+		if m := rb.tree.MatchAll(path); len(m) == 0 || m[0].Path != path {
+			rb.placeholders[path] = true
+		}
+		err = rb.tree.AddKeys(url, nil)
+		if err != nil {
+			return err
+		}
+		if r.Conditions != nil {
+			rb.conditional[path] = append(rb.conditional[path], conditional{url, r.Conditions, w, e, captures, keys})
+		} else {
+			rb.timed[path] = append(rb.timed[path], timed{url, w, e, captures, keys})
+		}
+		return nil
+	}
+
+	// fmt.Printf("user: %s -> url: %s access: %s\n", subject, r.Url, access_flags)
+	delete(rb.placeholders, strings.TrimSuffix(url, "*"))
+	err = rb.tree.AddKeys(url, keys)
+	if err != nil {
+		return err
 	}
 
 	// fmt.Println(*rb.tree.Digraph())
@@ -144,11 +286,16 @@ func (rb Rulebase) Add(r *Rule) error {
 //Looks up a subject and url in the rulebase and returns the access flags as int
 //This only looksup the subject ignoring any groups the subjet is member of
 func (rb Rulebase) LookupSubject(subject string, url string) (int, error) {
+	url, err := Canonicalize(url, false)
+	if err != nil {
+		return 0, err
+	}
+
 	v, err := rb.tree.Match(url, subject)
 	if err != nil {
 		if err.Error() == "index does not exist" {
 			//If the subject is not present in the ACL for this prefix return the default access flags of this rb
-			return rb.default_access_flags, nil
+			return rb.defaultflags(url), nil
 		} else {
 			return 0, err
 		}
@@ -159,18 +306,92 @@ func (rb Rulebase) LookupSubject(subject string, url string) (int, error) {
 //Looks up a subject and url in the rulebase. This also looks up the groups the subject is member of and
 //returns the "combined" access flags.
 func (rb Rulebase) Lookup(subject string, url string) (int, error) {
-	var access_flags, group_flags, subject_flags int
+	return rb.Authorize(&Request{Subject: subject, Url: url})
+}
+
+//Looks up a request in the rulebase like Lookup. The request's query parameters and headers are
+//used to evaluate the conditions of conditional rules for the matching prefix.
+func (rb Rulebase) Authorize(req *Request) (int, error) {
+	return rb.authorize(req, nil)
+}
+
+//Authorizes req and, if d isn't nil, records in d how the access flags came about
+func (rb Rulebase) authorize(req *Request, d *Decision) (int, error) {
+	var access_flags int
+	subject := req.Subject
 
 	// fmt.Printf("Lookup: %s@%s\n", subject, url)
-	key_map, err := rb.tree.MatchPrefix(url)
+	url, err := Canonicalize(req.Url, false)
+	if err != nil {
+		return 0, err
+	}
+	if d != nil {
+		d.Url = url
+	}
+
+	//a client outside the networks a URL is restricted to gets no access at all
+	if len(rb.networks) > 0 && !rb.reachable(url, req) {
+		if d != nil {
+			d.Unreachable = true
+		}
+		return 0, nil
+	}
+
+	path, key_map, err := rb.tree.MatchPath(url)
 	if err != nil {
 		if err.Error() == "prefix does not match" {
-			//If the subject is not present in the ACL for this prefix return the default access flags of this rb
-			return rb.default_access_flags, nil
+			//If no rule matches the URL return the default access flags for it, or those for unknown hosts
+			if d != nil {
+				d.UnknownHost = rb.unknown_host_flags != nil && !rb.configured(url)
+				d.Default = rb.unmatchedflags(url)
+			}
+			return rb.unmatchedflags(url), nil
 		} else {
 			return 0, err
 		}
 	}
+	ev := &evaluation{rb: rb, req: req, url: url}
+	a, err := rb.resolve(url, path, key_map, subject, ev)
+	if err != nil {
+		return 0, err
+	}
+	access_flags = rb.flags(a.keys, subject)
+	if d != nil {
+		if a.rule != "" {
+			d.Rule = rb.ruleurl(a.rule)
+		}
+		d.Conditional = a.conditional
+		if a.inherited != "" {
+			d.Inherited = rb.ruleurl(a.inherited)
+		}
+		d.Groups = rb.contributing(a.keys, subject)
+	}
+
+	//grants of rules with a window that is currently open and an expression that holds are added
+	//to the access flags. Grants that add nothing for the subject aren't evaluated at all.
+	if grants, exists := rb.timed[path]; exists {
+		now := rb.now()
+		for _, g := range grants {
+			flags := rb.flags(g.keys, subject)
+			if flags&^access_flags != 0 && (g.window == nil || g.window.contains(now)) && ev.match(g.expression, g.captures, path) {
+				access_flags |= flags
+				if d != nil {
+					d.Grants = append(d.Grants, g.url)
+				}
+			}
+		}
+	}
+
+	if d != nil {
+		d.Default = rb.defaultflags(url)
+	}
+	return access_flags | rb.defaultflags(url), nil
+}
+
+//Returns the access flags key_map grants subject, directly or through the groups it is member of
+func (rb Rulebase) flags(key_map map[string]int, subject string) int {
+	var group_flags, subject_flags int
+
 	subject_flags = key_map[subject] //if subject doesn't exist subject_flags are 0.
 	// fmt.Printf("  subject_flags(%s) %08b\n", subject, subject_flags)
 
@@ -182,15 +403,120 @@ func (rb Rulebase) Lookup(subject string, url string) (int, error) {
 		for _, g := range groups {
 			if flags, exists := key_map[g]; exists {
 				//get this group's access flags and OR it with the previously aggregated access flags
-				fmt.Printf("  group_flags(%s): %08b\n", g, flags)
+				// fmt.Printf("  group_flags(%s): %08b\n", g, flags)
 				group_flags |= flags
 			}
 		}
 	}
 
-	access_flags = subject_flags | group_flags | rb.default_access_flags
+	return subject_flags | group_flags
+}
 
-	return access_flags, nil
+//Reports whether key_map has an entry for subject or one of the groups it is member of
+func (rb Rulebase) mentions(key_map map[string]int, subject string) bool {
+	if _, exists := key_map[subject]; exists {
+		return true
+	}
+	for _, g := range rb.group[subject] {
+		if _, exists := key_map[g]; exists {
+			return true
+		}
+	}
+	return false
+}
+
+//Returns the path and ACL of the rule that applies to url, which the tree matched at path with
+//key_map. A node that only exists for conditional rules, rules with windows or expressions and
+//role bindings has no rule of its own: requests get the ACL of the nearest wildcard rule above
+//it, as if the node didn't exist, with the entries of role bindings at the node and at the
+//wildcard nodes in between added on top. If there is no rule above it "" and the role entries
+//alone are returned.
+func (rb Rulebase) ruleacl(url string, path string, key_map map[string]int) (string, map[string]int) {
+	if !rb.placeholders[path] {
+		return path, key_map
+	}
+	roles := []map[string]int{key_map}
+	rule, keys := "", map[string]int(nil)
+	for _, m := range rb.tree.MatchAll(url) {
+		if len(m.Path) >= len(path) {
+			continue
+		}
+		if !rb.placeholders[m.Path] {
+			rule, keys = m.Path, m.Keys
+			break
+		}
+		roles = append(roles, m.Keys)
+	}
+
+	var merged map[string]int
+	for _, r := range roles {
+		for k, v := range r {
+			if merged == nil {
+				merged = make(map[string]int, len(keys))
+				for k, v := range keys {
+					merged[k] = v
+				}
+			}
+			merged[k] |= v
+		}
+	}
+	if merged == nil {
+		return rule, keys
+	}
+	return rule, merged
+}
+
+//ACL deciding the access of a subject on a URL, see resolve
+type resolved struct {
+	rule        string //path of the rule that applies to the URL, "" if there is none
+	keys        map[string]int
+	conditional bool   //keys are those of a conditional rule for the URL
+	inherited   string //path of the wildcard prefix keys were inherited from, "" if they weren't
+}
+
+//Returns the ACL that decides the access subject has on url, which the tree matched at path
+//with key_map: the ACL of the rule that applies to url, see ruleacl, or of the first conditional
+//rule for path whose conditions ev satisfies. If the rulebase inherits access and that ACL
+//doesn't mention subject, the ACL of the nearest wildcard prefix above path that does is
+//inherited instead, or none if there is no such prefix. Without a request to evaluate (ev nil)
+//conditional rules are left out. Every lookup of the access of a subject goes through here, so
+//Authorize, Diff, WhoCan and WhatCan agree on it.
+func (rb Rulebase) resolve(url string, path string, key_map map[string]int, subject string, ev *evaluation) (resolved, error) {
+	var a resolved
+	var err error
+
+	a.rule, a.keys = rb.ruleacl(url, path, key_map)
+	if ev != nil {
+		a.keys, a.conditional, err = rb.conditionalkeys(path, a.keys, ev)
+		if err != nil {
+			return a, err
+		}
+		if a.conditional {
+			a.rule = path
+		}
+	}
+	if !rb.inherit || rb.mentions(a.keys, subject) {
+		return a, nil
+	}
+
+	a.keys = nil
+	for _, m := range rb.tree.MatchAll(url) {
+		if len(m.Path) >= len(path) {
+			continue
+		}
+		_, keys := rb.ruleacl(url, m.Path, m.Keys)
+		if ev != nil {
+			keys, _, err = rb.conditionalkeys(m.Path, keys, ev)
+			if err != nil {
+				return a, err
+			}
+		}
+		if rb.mentions(keys, subject) {
+			a.keys, a.inherited = keys, m.Path
+			break
+		}
+	}
+	return a, nil
 }
 
 func Maprulebase(rules *[]Rule) (map[string]map[string]int, error) {
@@ -200,22 +526,9 @@ func Maprulebase(rules *[]Rule) (map[string]map[string]int, error) {
 		m := make(map[string]int)
 		for subject, access := range r.ACL {
 			// fmt.Printf("user: %s -> url: %s access: %s\n", subject, r.Url, access_flags)
-			access_flags := 0
-			for _, v := range access {
-				switch v {
-				case "GET":
-					access_flags += GET
-				case "PUT":
-					access_flags += PUT
-				case "POST":
-					access_flags += POST
-				case "DELETE":
-					access_flags += DELETE
-				case "UPDATE":
-					access_flags += UPDATE
-				default:
-					return nil, errors.New(fmt.Sprintf("Unknown HTTP verb %s\n", v))
-				}
+			access_flags, err := accessflags(access)
+			if err != nil {
+				return nil, err
 			}
 			m[subject] = access_flags
 		}
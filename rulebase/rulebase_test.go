@@ -1,13 +1,13 @@
 package rulebase
 
 import (
-	"authz/prefixtree"
 	"encoding/base64"
 	// "fmt"
 	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 )
@@ -113,7 +113,7 @@ func GenerateRandomString(s int) (string, error) {
 }
 
 var test_map_rb map[string]map[string]int
-var test_tree_rb *prefixtree.Tree
+var test_tree_rb *Rulebase
 
 func readtestconfig() {
 	config_filename := "./tmp_conf.yml"
@@ -126,12 +126,34 @@ func readtestconfig() {
 	if err != nil {
 		log.Fatalf("Cpuldn't read configuration file %s (%s)", config_filename, err)
 	}
-	test_tree_rb, err = Create(conf)
+	test_tree_rb, err = Create(&conf.Rules)
 	if err != nil {
 		log.Fatalf("Couldn't create rulebase from configuration (%s)", err)
 	}
 
-	test_map_rb, _ = Maprulebase(conf)
+	test_map_rb, _ = Maprulebase(&conf.Rules)
+}
+
+//Creates a rulebase from the configuration conf
+func createtestrulebase(t *testing.T, conf string) *Rulebase {
+	t.Helper()
+
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
+	err := ioutil.WriteFile(config_filename, []byte(conf), 0644)
+	if err != nil {
+		t.Fatalf("Can't write config file %s", config_filename)
+	}
+
+	c, err := Readconfig(config_filename)
+	if err != nil {
+		t.Fatalf("Couldn't read configuration file %s (%s)", config_filename, err)
+	}
+	rb, err := Create(&c.Rules)
+	if err != nil {
+		t.Fatalf("Couldn't create rulebase from configuration (%s)", err)
+	}
+
+	return rb
 }
 
 func TestMain(m *testing.M) {
@@ -144,10 +166,9 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-//TODO: Test with invalid_test_conf2 file seg faults and halts the tests. How can this be avoided?
 func TestCreaterulebaseWithInvalidConfig(t *testing.T) {
 	var err error
-	config_filename := "./tmp_conf.yml"
+	config_filename := filepath.Join(t.TempDir(), "conf.yml")
 
 	err = ioutil.WriteFile(config_filename, []byte(invalid_test_conf1), 0644)
 	if err != nil {
@@ -156,27 +177,26 @@ func TestCreaterulebaseWithInvalidConfig(t *testing.T) {
 
 	conf, err := Readconfig(config_filename)
 	if err != nil {
-		t.Error(err)
+		t.Fatal(err)
 	}
-	test_tree_rb, err = Create(conf)
+	_, err = Create(&conf.Rules)
 	if err == nil {
 		t.Error("Create() didn't fail with invalid config file 1 (URL containing `*` not at the end)")
 	}
 
-	// err = ioutil.WriteFile(config_filename, []byte(invalid_test_conf2), 0644)
-	// if err != nil {
-	// 	t.Fatalf("Can't write config file %s", config_filename)
-	// }
-
-	// conf, err = Readconfig(config_filename)
-	// if err != nil {
-	// 	t.Error(err)
-	// }
-	// test_tree_rb, err = Create(conf)
-	// if err == nil {
-	// 	t.Error("Create() didn't fail with invalid config file 2 (invalid access flags)")
-	// }
+	err = ioutil.WriteFile(config_filename, []byte(invalid_test_conf2), 0644)
+	if err != nil {
+		t.Fatalf("Can't write config file %s", config_filename)
+	}
 
+	_, err = Readconfig(config_filename)
+	if err == nil {
+		t.Error("Readconfig() didn't fail with invalid config file 2 (invalid access flags)")
+	}
+	diagnostics := Validate(config_filename)
+	if len(diagnostics) != 1 || diagnostics[0].Line != 8 || diagnostics[0].Column != 13 {
+		t.Errorf("Wrong diagnostics %v for invalid config file 2, expected one for line 8, column 13", diagnostics)
+	}
 }
 
 func TestInsertLookupMap(t *testing.T) {
@@ -207,7 +227,7 @@ func TestInsertLookupTree(t *testing.T) {
 	var config_file string = "test_conf.yml"
 	conf, _ := Readconfig(config_file)
 
-	test_tree_rb, err = Create(conf)
+	test_tree_rb, err = Create(&conf.Rules)
 	if err != nil {
 		t.Errorf("Can't create rulebase using config file %s\n", config_file)
 	}
@@ -215,7 +235,7 @@ func TestInsertLookupTree(t *testing.T) {
 	subject := "John"
 	url := "www.corpA.com/home"
 
-	val, err := Lookup(subject, url, test_tree_rb)
+	val, err := test_tree_rb.LookupSubject(subject, url)
 	if err != nil {
 		t.Fatalf("Error %s\n", err)
 	}
@@ -226,7 +246,7 @@ func TestInsertLookupTree(t *testing.T) {
 	subject = "anonymous"
 	url = "www.corpA.com/"
 
-	val, err = Lookup(subject, url, test_tree_rb)
+	val, err = test_tree_rb.LookupSubject(subject, url)
 	if err.Error() != "key does not exist" {
 		t.Fatalf("This should fail to find the subject %s, but failed with error: %s\n", subject, err)
 	}
@@ -237,7 +257,7 @@ func TestInsertLookupTree(t *testing.T) {
 	subject = "anonymous"
 	url = "www.public.org/main"
 
-	val, err = Lookup(subject, url, test_tree_rb)
+	val, err = test_tree_rb.LookupSubject(subject, url)
 	if err != nil {
 		t.Fatalf("Error %s\n", err)
 	}
@@ -248,7 +268,7 @@ func TestInsertLookupTree(t *testing.T) {
 	subject = "anonymous"
 	url = "www.public.org/secret"
 
-	val, err = Lookup(subject, url, test_tree_rb)
+	val, err = test_tree_rb.LookupSubject(subject, url)
 	if err != nil {
 		t.Fatalf("Error %s\n", err)
 	}
@@ -264,11 +284,73 @@ func TestInsertLookupTree(t *testing.T) {
 
 var conf *Config
 var rb_map map[string]map[string]int
-var rb_tree *prefixtree.Tree
+var rb_tree *Rulebase
 var initialized, rb_tree_init, rb_map_init int
 var num_subjects int = 200
 var num_urls int = 100
 
+const inheritance_test_conf = `---
+title: "Rulebase with nested prefixes"
+
+rules:
+  - Url: www.corpA.com/*
+    ACL:
+      Jim: [GET]
+      John: [GET, PUT]
+      developers: [GET]
+  - Url: www.corpA.com/admin/*
+    ACL:
+      Jack: [GET, DELETE]
+      John: []
+  - Url: www.corpA.com/admin/users
+    ACL:
+      Jack: [GET]`
+
+func TestInheritance(t *testing.T) {
+	cases := []struct {
+		subject, url      string
+		override, inherit int
+	}{
+		{"Jim", "www.corpA.com/index.html", GET, GET},
+		{"Jim", "www.corpA.com/admin/", 0, GET},
+		{"Jim", "www.corpA.com/admin/users", 0, GET},
+		{"Jack", "www.corpA.com/admin/users", GET, GET},
+		{"Jack", "www.corpA.com/admin/logs", GET | DELETE, GET | DELETE},
+		{"Jack", "www.corpA.com/index.html", 0, 0},
+		//an empty ACL entry stops inheritance
+		{"John", "www.corpA.com/admin/logs", 0, 0},
+		{"John", "www.corpA.com/admin/users", 0, 0},
+		//groups are inherited like subjects
+		{"Jane", "www.corpA.com/admin/users", 0, GET},
+	}
+
+	for _, mode := range []string{OVERRIDE, INHERIT} {
+		rb := createtestrulebase(t, inheritance_test_conf)
+		rb.AddGroup("developers", []string{"Jane"})
+		err := rb.SetInheritance(mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, c := range cases {
+			expected := c.override
+			if mode == INHERIT {
+				expected = c.inherit
+			}
+			access, err := rb.Lookup(c.subject, c.url)
+			if err != nil {
+				t.Errorf("Lookup %s:%s failed (%s)", c.subject, c.url, err)
+			} else if access != expected {
+				t.Errorf("Wrong authorization value %d for %s:%s in %s mode, should be %d", access, c.subject, c.url, mode, expected)
+			}
+		}
+	}
+
+	if New().SetInheritance("merge") == nil {
+		t.Errorf("Setting an unknown inheritance mode should fail")
+	}
+}
+
 func createconfig(num_subjects int, num_urls int) *Config {
 	var conf Config
 	var acl map[string][]string
@@ -291,10 +373,7 @@ func createconfig(num_subjects int, num_urls int) *Config {
 			}
 		}
 
-		conf.Rules = append(conf.Rules, struct {
-			Url string              `yaml:"Url"`
-			ACL map[string][]string `yaml:"ACL"`
-		}{url, acl})
+		conf.Rules = append(conf.Rules, Rule{Url: url, ACL: acl})
 	}
 
 	return &conf
@@ -311,7 +390,7 @@ func BenchmarkLookupTree(b *testing.B) {
 	}
 
 	if rb_tree_init == 0 {
-		rb_tree, _ = Create(conf)
+		rb_tree, _ = Create(&conf.Rules)
 		rb_tree_init = 1
 	}
 
@@ -349,12 +428,12 @@ func BenchmarkLookupTree(b *testing.B) {
 
 	// fmt.Printf("longest url has %d chars, avg url length is %d\n", l, avg/len(urls))
 
-	Lookup("fwgrgerwghwe", url, rb_tree)
+	rb_tree.Lookup("fwgrgerwghwe", url)
 
 	b.ResetTimer()
 
 	for i := 0; i < b.N; i++ {
-		Lookup(subject, urls[i%len(urls)], rb_tree)
+		rb_tree.Lookup(subject, urls[i%len(urls)])
 	}
 
 }
@@ -369,7 +448,7 @@ func BenchmarkLookupMap(b *testing.B) {
 		initialized = 1
 	}
 	if rb_map_init == 0 {
-		rb_map, _ = Maprulebase(conf)
+		rb_map, _ = Maprulebase(&conf.Rules)
 		rb_map_init = 1
 	}
 
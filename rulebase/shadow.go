@@ -0,0 +1,161 @@
+package rulebase
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+//Disagreement is a request the candidate rulebase of a Shadow decides differently than the
+//enforcing one, or only one of them fails to evaluate. Enforced and Candidate are the access
+//flags each of them returned.
+type Disagreement struct {
+	Request     *Request
+	Enforced    int
+	Candidate   int
+	EnforcedErr error //the error the enforcing rulebase returned, if any
+	Err         error //the error the candidate returned, if any
+}
+
+//Shadow evaluates a candidate rulebase alongside the enforcing one. Requests are authorized by
+//the enforcing rulebase only; the candidate sees the same requests, its decisions are compared
+//and disagreements are counted and passed to a logging function, but they never change a
+//response. This allows to try policy changes on live traffic before enforcing them.
+//
+//The candidate evaluates requests on a goroutine of its own, so it adds no latency to
+//Authorize. Requests are queued for it and dropped when the queue is full, e.g. because the
+//candidate is much slower than the enforcing rulebase; Dropped counts them.
+//
+//For requests with a method the decisions compared are whether the method is allowed, for
+//other requests the access flags.
+type Shadow struct {
+	enforcing *Rulebase
+
+	lock      sync.RWMutex
+	candidate *Rulebase
+	closed    bool
+	log       func(*Disagreement)
+	queue     chan shadowed
+	done      chan struct{}
+
+	requests      uint64
+	disagreements uint64
+	dropped       uint64
+}
+
+//A request queued for the candidate, with the access flags and error the enforcing rulebase
+//returned
+type shadowed struct {
+	req          Request
+	access_flags int
+	err          error
+	candidate    *Rulebase
+}
+
+//Number of requests that can wait for the candidate before further ones are dropped
+const shadowqueue = 1024
+
+//Creates a shadow evaluation of candidate next to the enforcing rulebase. log is called for
+//every disagreement, from the goroutine that evaluates the candidate, and may be nil. Close
+//stops the goroutine.
+func NewShadow(enforcing *Rulebase, candidate *Rulebase, log func(*Disagreement)) *Shadow {
+	s := &Shadow{enforcing: enforcing, candidate: candidate, log: log, queue: make(chan shadowed, shadowqueue), done: make(chan struct{})}
+	go s.evaluate()
+	return s
+}
+
+//Replaces the candidate rulebase and resets the counters. A nil candidate stops the shadow
+//evaluation. Requests still queued for the previous candidate are discarded.
+func (s *Shadow) SetCandidate(candidate *Rulebase) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.candidate = candidate
+	atomic.StoreUint64(&s.requests, 0)
+	atomic.StoreUint64(&s.disagreements, 0)
+	atomic.StoreUint64(&s.dropped, 0)
+}
+
+//Evaluates the requests queued for the candidate until the queue is closed
+func (s *Shadow) evaluate() {
+	defer close(s.done)
+
+	for r := range s.queue {
+		s.lock.RLock()
+		current := s.candidate
+		s.lock.RUnlock()
+		if r.candidate != current {
+			continue
+		}
+
+		atomic.AddUint64(&s.requests, 1)
+		//requests both rulebases fail to evaluate are refused by both
+		candidate_flags, err := r.candidate.Authorize(&r.req)
+		if (err != nil) != (r.err != nil) || err == nil && !agree(&r.req, r.access_flags, candidate_flags) {
+			atomic.AddUint64(&s.disagreements, 1)
+			if s.log != nil {
+				s.log(&Disagreement{Request: &r.req, Enforced: r.access_flags, Candidate: candidate_flags, EnforcedErr: r.err, Err: err})
+			}
+		}
+	}
+}
+
+//Authorizes a request with the enforcing rulebase and queues it for the candidate, also if the
+//enforcing rulebase fails to evaluate it. Only the result of the enforcing rulebase is returned.
+//The candidate evaluates a copy of req later, the maps it refers to, like its header, must not
+//be changed after Authorize returns.
+func (s *Shadow) Authorize(req *Request) (int, error) {
+	access_flags, err := s.enforcing.Authorize(req)
+	if err != nil {
+		access_flags = 0
+	}
+
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if s.candidate == nil || s.closed {
+		return access_flags, err
+	}
+	select {
+	case s.queue <- shadowed{*req, access_flags, err, s.candidate}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+
+	return access_flags, err
+}
+
+//Evaluates the requests still queued for the candidate and stops the shadow evaluation.
+//Authorize keeps answering requests with the enforcing rulebase.
+func (s *Shadow) Close() {
+	s.lock.Lock()
+	if !s.closed {
+		s.closed = true
+		close(s.queue)
+	}
+	s.lock.Unlock()
+	<-s.done
+}
+
+//Returns the number of requests dropped since the candidate was set because the queue of the
+//candidate was full
+func (s *Shadow) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+//Returns the number of requests the candidate evaluated and the number of disagreements since
+//the candidate was set
+func (s *Shadow) Stats() (requests uint64, disagreements uint64) {
+	return atomic.LoadUint64(&s.requests), atomic.LoadUint64(&s.disagreements)
+}
+
+//Reports whether the access flags a and b lead to the same decision for req
+func agree(req *Request, a int, b int) bool {
+	if req.Method == "" {
+		return a == b
+	}
+	method, err := accessflags([]string{strings.ToUpper(req.Method)})
+	if err != nil {
+		return a == b
+	}
+	return (a&method != 0) == (b&method != 0)
+}
@@ -0,0 +1,86 @@
+package rulebase
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestShadow(t *testing.T) {
+	enforcing, candidate := New(), New()
+	err := enforcing.Add(&Rule{Url: "www.corpA.com/*", ACL: map[string][]string{"Jim": {"GET", "PUT"}, "John": {"GET"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = candidate.Add(&Rule{Url: "www.corpA.com/*", ACL: map[string][]string{"Jim": {"GET"}, "John": {"GET", "POST"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var lock sync.Mutex
+	var logged []*Disagreement
+	s := NewShadow(enforcing, nil, func(d *Disagreement) {
+		lock.Lock()
+		defer lock.Unlock()
+		logged = append(logged, d)
+	})
+
+	//without a candidate nothing is evaluated
+	s.Authorize(&Request{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/wiki"})
+	s.SetCandidate(candidate)
+
+	cases := []struct {
+		subject, method string
+		access_flags    int
+	}{
+		{"Jim", "PUT", GET | PUT},
+		{"Jim", "GET", GET | PUT},
+		{"John", "GET", GET},
+		{"John", "", GET},
+	}
+
+	for _, c := range cases {
+		access_flags, err := s.Authorize(&Request{Subject: c.subject, Method: c.method, Url: "www.corpA.com/wiki"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if access_flags != c.access_flags {
+			t.Errorf("%s %s got access flags %d instead of the enforcing rulebase's %d", c.method, c.subject, access_flags, c.access_flags)
+		}
+	}
+	s.Close()
+
+	requests, disagreements := s.Stats()
+	if requests != 4 || disagreements != 2 || s.Dropped() != 0 {
+		t.Errorf("Got %d requests, %d disagreements and %d dropped, expected 4, 2 and 0", requests, disagreements, s.Dropped())
+	}
+	if len(logged) != 2 || logged[0].Request.Subject != "Jim" || logged[0].Enforced != GET|PUT || logged[0].Candidate != GET {
+		t.Errorf("Wrong disagreements logged: %+v", logged)
+	}
+
+	//requests the enforcing rulebase fails to evaluate are evaluated by the candidate as well
+	err = enforcing.Add(&Rule{Url: "www.corpA.com/*", Conditions: &Conditions{Query: map[string]string{"beta": "1"}}, ACL: map[string][]string{"Jim": {"GET"}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	logged = nil
+	s = NewShadow(enforcing, candidate, func(d *Disagreement) {
+		lock.Lock()
+		defer lock.Unlock()
+		logged = append(logged, d)
+	})
+	_, err = s.Authorize(&Request{Subject: "John", Method: "GET", Url: "www.corpA.com/wiki?q=%zz"})
+	if err == nil {
+		t.Errorf("The enforcing rulebase should fail on an invalid query")
+	}
+	s.Close()
+	requests, disagreements = s.Stats()
+	if requests != 1 || disagreements != 1 || len(logged) != 1 || logged[0].EnforcedErr == nil || logged[0].Err != nil || logged[0].Candidate != GET|POST {
+		t.Errorf("Got %d requests and %d disagreements, logged %+v, expected the enforcing rulebase's error", requests, disagreements, logged)
+	}
+
+	//after Close requests are still answered by the enforcing rulebase
+	access_flags, err := s.Authorize(&Request{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/wiki"})
+	if err != nil || access_flags != GET|PUT {
+		t.Errorf("Got access flags %d (%v) after Close, expected %d", access_flags, err, GET|PUT)
+	}
+}
@@ -0,0 +1,251 @@
+package rulebase
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+//A snapshot starts with a header of the magic bytes, the version of the format, the CRC-32C
+//checksum of the payload and its length. All numbers are little endian.
+const (
+	snapshotmagic   = "AUTHZSNP"
+	snapshotversion = 1
+	snapshotheader  = len(snapshotmagic) + 4 + 4 + 8
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+//The payload of a snapshot. The ACL and default policy trees are stored in their binary
+//encoding and restored without inserting their prefixes again. The network restrictions,
+//conditional rules and rules with windows or expressions are stored as rules and added again
+//when the snapshot is read, since they hold compiled expressions and parsed windows.
+//
+//gob writes maps in the order it ranges over them, so the payload has no maps and everything
+//in it is sorted: compiling the same rulebase twice gives the same snapshot. The rules are
+//stored as JSON, which writes the keys of their ACLs and conditions sorted.
+type snapshot struct {
+	Inheritance    bool
+	DefaultAccess  int
+	UnknownHost    int
+	HasUnknownHost bool //gob doesn't keep a pointer to 0
+	Groups         []string
+	Members        []members
+	Attributes     []attributes
+	TrustedProxies []string
+	Placeholders   []string //paths whose node only exists for conditional and timed rules
+	Tree           []byte
+	Defaults       []byte
+	Rules          []byte
+}
+
+//The groups of a subject
+type members struct {
+	Subject string
+	Groups  []string
+}
+
+//The attributes of a subject, sorted by name
+type attributes struct {
+	Subject string
+	Names   []string
+	Values  []string
+}
+
+//Writes a snapshot of the rulebase to w. A snapshot is a versioned binary file with a checksum
+//that ReadSnapshot turns into the same rulebase much faster than its configuration can be
+//parsed and built, see the compile command of authz.
+func (rb Rulebase) WriteSnapshot(w io.Writer) error {
+	s := snapshot{
+		Inheritance:    rb.inherit,
+		DefaultAccess:  rb.default_access_flags,
+		Groups:         append([]string{}, rb.Groups...),
+		TrustedProxies: cidrs(rb.trusted),
+	}
+	sort.Strings(s.Groups)
+	if rb.unknown_host_flags != nil {
+		s.UnknownHost, s.HasUnknownHost = *rb.unknown_host_flags, true
+	}
+
+	var err error
+	s.Tree, err = rb.tree.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	s.Defaults, err = rb.defaults.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	for path := range rb.placeholders {
+		s.Placeholders = append(s.Placeholders, path)
+	}
+	sort.Strings(s.Placeholders)
+
+	for subject, groups := range rb.group {
+		groups = append([]string{}, groups...)
+		sort.Strings(groups)
+		s.Members = append(s.Members, members{subject, groups})
+	}
+	sort.Slice(s.Members, func(i, j int) bool {
+		return s.Members[i].Subject < s.Members[j].Subject
+	})
+	for subject, values := range rb.attributes {
+		a := attributes{Subject: subject, Names: []string{}, Values: []string{}}
+		for name := range values {
+			a.Names = append(a.Names, name)
+		}
+		sort.Strings(a.Names)
+		for _, name := range a.Names {
+			a.Values = append(a.Values, values[name])
+		}
+		s.Attributes = append(s.Attributes, a)
+	}
+	sort.Slice(s.Attributes, func(i, j int) bool {
+		return s.Attributes[i].Subject < s.Attributes[j].Subject
+	})
+
+	//the rules of a path keep their order, it is the order they are evaluated in
+	rules := make(map[string][]Rule)
+	rb.exportrules(rules)
+	paths := make([]string, 0, len(rules))
+	for path := range rules {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	var sorted []Rule
+	for _, path := range paths {
+		sorted = append(sorted, rules[path]...)
+	}
+	s.Rules, err = json.Marshal(sorted)
+	if err != nil {
+		return err
+	}
+
+	var payload bytes.Buffer
+	err = gob.NewEncoder(&payload).Encode(&s)
+	if err != nil {
+		return err
+	}
+
+	header := make([]byte, snapshotheader)
+	copy(header, snapshotmagic)
+	binary.LittleEndian.PutUint32(header[8:], snapshotversion)
+	binary.LittleEndian.PutUint32(header[12:], crc32.Checksum(payload.Bytes(), castagnoli))
+	binary.LittleEndian.PutUint64(header[16:], uint64(payload.Len()))
+
+	_, err = w.Write(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(payload.Bytes())
+	return err
+}
+
+//Creates a rulebase from a snapshot written by WriteSnapshot. Snapshots of another version of
+//the format and snapshots whose checksum doesn't match are rejected.
+func ReadSnapshot(data []byte) (*Rulebase, error) {
+	if !issnapshot(data) {
+		return nil, errors.New("not a rulebase snapshot")
+	}
+	if len(data) < snapshotheader {
+		return nil, errors.New("truncated snapshot header")
+	}
+	version := binary.LittleEndian.Uint32(data[8:])
+	if version != snapshotversion {
+		return nil, errors.New(fmt.Sprintf("unsupported snapshot version %d, expected %d", version, snapshotversion))
+	}
+	payload := data[snapshotheader:]
+	if uint64(len(payload)) != binary.LittleEndian.Uint64(data[16:]) {
+		return nil, errors.New(fmt.Sprintf("snapshot has %d bytes of payload, expected %d", len(payload), binary.LittleEndian.Uint64(data[16:])))
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.LittleEndian.Uint32(data[12:]) {
+		return nil, errors.New("snapshot checksum mismatch")
+	}
+
+	var s snapshot
+	err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&s)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid snapshot (%s)", err))
+	}
+
+	rb := New()
+	err = rb.tree.UnmarshalBinary(s.Tree)
+	if err != nil {
+		return nil, err
+	}
+	err = rb.defaults.UnmarshalBinary(s.Defaults)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	err = json.Unmarshal(s.Rules, &rules)
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("invalid snapshot (%s)", err))
+	}
+	for _, r := range rules {
+		err = rb.Add(&r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, path := range s.Placeholders {
+		rb.placeholders[path] = true
+	}
+
+	rb.inherit = s.Inheritance
+	rb.default_access_flags = s.DefaultAccess
+	if s.HasUnknownHost {
+		rb.unknown_host_flags = &s.UnknownHost
+	}
+	rb.Groups = s.Groups
+	for _, m := range s.Members {
+		rb.group[m.Subject] = m.Groups
+	}
+	for _, a := range s.Attributes {
+		rb.attributes[a.Subject] = make(map[string]string, len(a.Names))
+		for i, name := range a.Names {
+			rb.attributes[a.Subject][name] = a.Values[i]
+		}
+	}
+	err = rb.SetTrustedProxies(s.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+
+	return rb, nil
+}
+
+//Reads the snapshot file filename, see ReadSnapshot
+func LoadSnapshot(filename string) (*Rulebase, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ReadSnapshot(data)
+}
+
+//Reports whether data starts like a snapshot
+func issnapshot(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(snapshotmagic))
+}
+
+//Reports whether filename is a snapshot file rather than a configuration
+func issnapshotfile(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	magic := make([]byte, len(snapshotmagic))
+	_, err = io.ReadFull(f, magic)
+	return err == nil && issnapshot(magic)
+}
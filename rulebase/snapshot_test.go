@@ -0,0 +1,145 @@
+package rulebase
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": export_test_conf})
+	rb, err := Load(filepath.Join(dir, "conf.yml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = rb.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(dir, "conf.snap")
+	err = ioutil.WriteFile(filename, buf.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	//Load recognizes snapshots
+	loaded, err := Load(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected, err := rb.Export().Encode("yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	exported, err := loaded.Export().Encode("yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(exported) != string(expected) {
+		t.Errorf("The snapshot loads into\n%s\ninstead of\n%s", exported, expected)
+	}
+
+	for _, req := range []*Request{
+		{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1"},
+		{Subject: "Jim", Method: "PUT", Url: "www.corpA.com/admin/users", RemoteAddr: "192.168.1.1"},
+		{Subject: "John", Method: "DELETE", Url: "www.corpA.com/admin/users", RemoteAddr: "10.8.1.1", Header: http.Header{"X-Break-Glass": {"1"}}},
+		{Subject: "Jack", Method: "PUT", Url: "www.corpA.com/wiki/home"},
+		{Subject: "Jim", Method: "GET", Url: "www.corpA.com/reports/2026/q1"},
+		{Subject: "Bob", Method: "POST", Url: "www.corpB.com/form"},
+		{Subject: "Bob", Method: "GET", Url: "www.corpC.com/"},
+	} {
+		expected, err := rb.Explain(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d, err := loaded.Explain(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Access != expected.Access {
+			t.Errorf("%s %s %s gets access %d from the snapshot instead of %d", req.Subject, req.Method, req.Url, d.Access, expected.Access)
+		}
+	}
+}
+
+//Compiling the same configuration again must give the same snapshot
+func TestSnapshotDeterministic(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": export_test_conf})
+
+	var first []byte
+	for i := 0; i < 10; i++ {
+		rb, err := Load(filepath.Join(dir, "conf.yml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		err = rb.WriteSnapshot(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if first == nil {
+			first = buf.Bytes()
+		} else if !bytes.Equal(buf.Bytes(), first) {
+			t.Fatalf("Compiling the configuration again gave a different snapshot")
+		}
+	}
+}
+
+func TestSnapshotInvalid(t *testing.T) {
+	var buf bytes.Buffer
+	rb, err := Create(&[]Rule{{Url: "www.corpA.com/*", ACL: map[string][]string{"Jim": {"GET"}}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = rb.WriteSnapshot(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	corrupt := func(i int, b byte) []byte {
+		c := append([]byte{}, data...)
+		c[i] = b
+		return c
+	}
+	for name, c := range map[string]struct {
+		data  []byte
+		error string
+	}{
+		"a configuration":     {[]byte(test_conf), "not a rulebase snapshot"},
+		"a truncated header":  {data[:12], "truncated snapshot header"},
+		"another version":     {corrupt(8, 2), "unsupported snapshot version 2"},
+		"a truncated payload": {data[:len(data)-1], "bytes of payload"},
+		"a corrupt payload":   {corrupt(len(data)-1, data[len(data)-1]^1), "checksum mismatch"},
+	} {
+		_, err := ReadSnapshot(c.data)
+		if err == nil || !strings.Contains(err.Error(), c.error) {
+			t.Errorf("Reading %s returned %v instead of %q", name, err, c.error)
+		}
+	}
+}
+
+func BenchmarkBuild(b *testing.B) {
+	conf := createconfig(num_subjects, num_urls)
+
+	for i := 0; i < b.N; i++ {
+		Build(conf)
+	}
+}
+
+func BenchmarkReadSnapshot(b *testing.B) {
+	rb, _ := Build(createconfig(num_subjects, num_urls))
+	var buf bytes.Buffer
+	rb.WriteSnapshot(&buf)
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		ReadSnapshot(buf.Bytes())
+	}
+}
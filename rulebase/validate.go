@@ -0,0 +1,534 @@
+package rulebase
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+//Diagnostic is a problem found in a configuration file by Validate
+type Diagnostic struct {
+	File         string
+	Line, Column int
+	Message      string
+}
+
+func (d Diagnostic) String() string {
+	if d.Line == 0 {
+		return fmt.Sprintf("%s: %s", d.File, d.Message)
+	} else if d.Column == 0 {
+		return fmt.Sprintf("%s:%d: %s", d.File, d.Line, d.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", d.File, d.Line, d.Column, d.Message)
+}
+
+//Validates the configuration file or directory filename and the files it includes against the
+//configuration schema and returns every problem found, sorted by file and position. Besides
+//what makes loading fail, like values of the wrong type, unknown verbs, wildcards that aren't
+//at the end of a URL and invalid windows, networks or expressions, it reports
+//
+//	- unknown keys
+//	- group names that are also subject names, i.e. group members or declared subjects
+//	- groups, subjects and roles defined more than once and role bindings of undefined roles
+//	- rules without conditions, windows or expressions for the same URL
+//	- ACL entries and role bindings for undefined groups, if the configuration declares its
+//	  subjects: once there is a subjects section every name in an ACL must be a group, a group
+//	  member or a declared subject
+func Validate(filename string) []Diagnostic {
+	v := validator{
+		seen:     make(map[string]bool),
+		groups:   make(map[string]position),
+		members:  make(map[string]bool),
+		subjects: make(map[string]position),
+		roles:    make(map[string]position),
+		urls:     make(map[string]position),
+	}
+	v.file(filename)
+	v.check()
+
+	sort.SliceStable(v.diagnostics, func(i, j int) bool {
+		a, b := v.diagnostics[i], v.diagnostics[j]
+		if a.File != b.File {
+			return a.File < b.File
+		} else if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return v.diagnostics
+}
+
+//A name used in an ACL or role binding and where it is used
+type reference struct {
+	name string
+	at   position
+}
+
+type validator struct {
+	diagnostics []Diagnostic
+	seen        map[string]bool
+
+	groups     map[string]position
+	members    map[string]bool
+	subjects   map[string]position
+	roles      map[string]position
+	urls       map[string]position
+	references []reference
+	bindings   []reference
+}
+
+func (v *validator) report(at position, format string, args ...interface{}) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{at.file, at.line, at.column, fmt.Sprintf(format, args...)})
+}
+
+func at(file string, n *yaml.Node) position {
+	return position{file, n.Line, n.Column}
+}
+
+var yamlline = regexp.MustCompile(`line (\d+): `)
+
+//Reports err, an error of the YAML decoder, at n or at the line the error names
+func (v *validator) yamlerror(file string, n *yaml.Node, err error) {
+	p := position{file: file}
+	if n != nil {
+		p = at(file, n)
+	}
+
+	message := strings.TrimPrefix(err.Error(), "yaml: ")
+	message = strings.TrimPrefix(message, "unmarshal errors:\n")
+	for _, line := range strings.Split(message, "\n") {
+		line = strings.TrimSpace(line)
+		if m := yamlline.FindStringSubmatchIndex(line); m != nil {
+			if n == nil {
+				p.line, _ = strconv.Atoi(line[m[2]:m[3]])
+			}
+			line = line[:m[0]] + line[m[1]:]
+		}
+		v.report(p, "%s", line)
+	}
+}
+
+//Validates a configuration file, or the files of a directory, and the files it includes
+func (v *validator) file(filename string) {
+	info, err := os.Stat(filename)
+	if err != nil {
+		v.report(position{file: filename}, "%s", err)
+		return
+	}
+	if info.IsDir() {
+		var files []string
+		for _, pattern := range configpatterns {
+			matches, _ := filepath.Glob(filepath.Join(filename, pattern))
+			files = append(files, matches...)
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			v.file(f)
+		}
+		return
+	}
+
+	if abs, err := filepath.Abs(filename); err == nil {
+		if v.seen[abs] {
+			return
+		}
+		v.seen[abs] = true
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		v.report(position{file: filename}, "%s", err)
+		return
+	}
+	root, err := parsedocument(filename, data)
+	if err != nil {
+		v.yamlerror(filename, nil, err)
+		return
+	}
+	if len(root.Content) == 0 {
+		return
+	}
+
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		v.report(at(filename, doc), "a configuration must be a mapping")
+		return
+	}
+	for i := 0; i+1 < len(doc.Content); i += 2 {
+		key, value := doc.Content[i], doc.Content[i+1]
+		switch key.Value {
+		case "title":
+			v.scalar(filename, value, "title")
+		case "inheritance":
+			if v.scalar(filename, value, "inheritance") {
+				if err := New().SetInheritance(value.Value); err != nil {
+					v.report(at(filename, value), "%s", err)
+				}
+			}
+		case "trusted_proxies":
+			for _, p := range v.list(filename, value, "trusted_proxies") {
+				if _, err := parsenets([]string{p.Value}); err != nil {
+					v.report(at(filename, p), "%s", err)
+				}
+			}
+		case "default_access", "unknown_host_access":
+			v.verbs(filename, value, key.Value)
+		case "defaults":
+			v.defaults(filename, value)
+		case "groups":
+			v.groupsection(filename, value)
+		case "subjects":
+			v.subjectsection(filename, value)
+		case "roles":
+			v.rolesection(filename, value)
+		case "role_bindings":
+			v.rolebindings(filename, value)
+		case "rules":
+			if value.Kind != yaml.SequenceNode {
+				v.report(at(filename, value), "rules must be a list")
+				continue
+			}
+			for _, r := range value.Content {
+				v.rule(filename, r)
+			}
+		case "tests":
+			if value.Kind != yaml.SequenceNode {
+				v.report(at(filename, value), "tests must be a list")
+				continue
+			}
+			for _, t := range value.Content {
+				v.test(filename, t)
+			}
+		case "include":
+			for _, pattern := range v.list(filename, value, "include") {
+				path := pattern.Value
+				if !filepath.IsAbs(path) {
+					path = filepath.Join(filepath.Dir(filename), path)
+				}
+				matches, err := filepath.Glob(path)
+				if err != nil || len(matches) == 0 {
+					v.report(at(filename, pattern), "include %s matches no files", pattern.Value)
+				}
+				sort.Strings(matches)
+				for _, m := range matches {
+					v.file(m)
+				}
+			}
+		default:
+			v.report(at(filename, key), "unknown key %s", key.Value)
+		}
+	}
+}
+
+//Reports whether n is a scalar and reports a problem if it isn't
+func (v *validator) scalar(file string, n *yaml.Node, what string) bool {
+	if n.Kind != yaml.ScalarNode {
+		v.report(at(file, n), "%s must be a single value", what)
+		return false
+	}
+	return true
+}
+
+//Returns the scalar items of the list n and reports the items that aren't scalars
+func (v *validator) list(file string, n *yaml.Node, what string) []*yaml.Node {
+	if n.Kind != yaml.SequenceNode {
+		v.report(at(file, n), "%s must be a list", what)
+		return nil
+	}
+
+	var items []*yaml.Node
+	for _, item := range n.Content {
+		if v.scalar(file, item, "an item of "+what) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+//Checks that n is a mapping and calls f for each of its entries
+func (v *validator) mapping(file string, n *yaml.Node, what string, f func(key *yaml.Node, value *yaml.Node)) {
+	if n.Kind != yaml.MappingNode {
+		v.report(at(file, n), "%s must be a mapping", what)
+		return
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		f(n.Content[i], n.Content[i+1])
+	}
+}
+
+//Checks a list of HTTP verbs
+func (v *validator) verbs(file string, n *yaml.Node, what string) {
+	for _, verb := range v.list(file, n, what+" (a list of HTTP verbs)") {
+		if _, err := accessflags([]string{verb.Value}); err != nil {
+			v.report(at(file, verb), "unknown HTTP verb %s", verb.Value)
+		}
+	}
+}
+
+//Checks a rule URL and returns it canonicalized, or "" if it is invalid
+func (v *validator) url(file string, n *yaml.Node) string {
+	if !v.scalar(file, n, "Url") {
+		return ""
+	}
+	if strings.Contains(strings.TrimSuffix(n.Value, "*"), "*") {
+		v.report(at(file, n), "illegal wildcard in %s, * is only allowed at the end of a URL", n.Value)
+		return ""
+	}
+	url, err := canonicalrule(n.Value)
+	if err != nil {
+		v.report(at(file, n), "invalid URL %s (%s)", n.Value, err)
+		return ""
+	}
+	return url
+}
+
+func (v *validator) defaults(file string, n *yaml.Node) {
+	if n.Kind != yaml.SequenceNode {
+		v.report(at(file, n), "defaults must be a list")
+		return
+	}
+	for _, d := range n.Content {
+		v.mapping(file, d, "a default policy", func(key *yaml.Node, value *yaml.Node) {
+			switch key.Value {
+			case "Url":
+				v.url(file, value)
+			case "Access":
+				v.verbs(file, value, "Access")
+			default:
+				v.report(at(file, key), "unknown key %s", key.Value)
+			}
+		})
+	}
+}
+
+func (v *validator) groupsection(file string, n *yaml.Node) {
+	v.mapping(file, n, "groups", func(key *yaml.Node, value *yaml.Node) {
+		if p, exists := v.groups[key.Value]; exists {
+			v.report(at(file, key), "group %s is already defined at %s", key.Value, p)
+		} else {
+			v.groups[key.Value] = at(file, key)
+		}
+		for _, member := range v.list(file, value, "the members of group "+key.Value) {
+			v.members[member.Value] = true
+		}
+	})
+}
+
+func (v *validator) subjectsection(file string, n *yaml.Node) {
+	v.mapping(file, n, "subjects", func(key *yaml.Node, value *yaml.Node) {
+		if p, exists := v.subjects[key.Value]; exists {
+			v.report(at(file, key), "subject %s is already defined at %s", key.Value, p)
+		} else {
+			v.subjects[key.Value] = at(file, key)
+		}
+		v.mapping(file, value, "the attributes of subject "+key.Value, func(name *yaml.Node, attribute *yaml.Node) {
+			v.scalar(file, attribute, "attribute "+name.Value)
+		})
+	})
+}
+
+func (v *validator) rolesection(file string, n *yaml.Node) {
+	v.mapping(file, n, "roles", func(key *yaml.Node, value *yaml.Node) {
+		if p, exists := v.roles[key.Value]; exists {
+			v.report(at(file, key), "role %s is already defined at %s", key.Value, p)
+		} else {
+			v.roles[key.Value] = at(file, key)
+		}
+		v.mapping(file, value, "role "+key.Value, func(path *yaml.Node, access *yaml.Node) {
+			v.verbs(file, access, "the access to "+path.Value)
+		})
+	})
+}
+
+func (v *validator) rolebindings(file string, n *yaml.Node) {
+	if n.Kind != yaml.SequenceNode {
+		v.report(at(file, n), "role_bindings must be a list")
+		return
+	}
+	for _, b := range n.Content {
+		scopes := false
+		v.mapping(file, b, "a role binding", func(key *yaml.Node, value *yaml.Node) {
+			switch key.Value {
+			case "Role":
+				if v.scalar(file, value, "Role") {
+					v.bindings = append(v.bindings, reference{value.Value, at(file, value)})
+				}
+			case "Subjects":
+				for _, s := range v.list(file, value, "Subjects") {
+					v.references = append(v.references, reference{s.Value, at(file, s)})
+				}
+			case "Scopes":
+				for _, s := range v.list(file, value, "Scopes") {
+					scopes = true
+					if strings.HasSuffix(s.Value, "*") {
+						v.report(at(file, s), "scope %s must not end in a wildcard", s.Value)
+					} else {
+						v.url(file, s)
+					}
+				}
+			default:
+				v.report(at(file, key), "unknown key %s", key.Value)
+			}
+		})
+		if b.Kind == yaml.MappingNode && !scopes {
+			v.report(at(file, b), "role binding without scopes")
+		}
+	}
+}
+
+func (v *validator) rule(file string, n *yaml.Node) {
+	var raw, url string
+	var has_url, has_acl, has_expression bool
+	var captures *yaml.Node
+	unconditional := true
+
+	v.mapping(file, n, "a rule", func(key *yaml.Node, value *yaml.Node) {
+		switch key.Value {
+		case "Url":
+			has_url = true
+			raw, url = value.Value, v.url(file, value)
+		case "Conditions":
+			unconditional = false
+			var c Conditions
+			if err := value.Decode(&c); err != nil {
+				v.yamlerror(file, value, err)
+			}
+		case "Window":
+			unconditional = false
+			var w Window
+			if err := value.Decode(&w); err != nil {
+				v.yamlerror(file, value, err)
+			} else if _, err := w.parse(); err != nil {
+				v.report(at(file, value), "invalid window (%s)", err)
+			}
+		case "Network":
+			var nw Network
+			if err := value.Decode(&nw); err != nil {
+				v.yamlerror(file, value, err)
+			} else if _, err := nw.parse(false); err != nil {
+				v.report(at(file, value), "invalid network (%s)", err)
+			}
+		case "Expression":
+			unconditional = false
+			has_expression = true
+			if v.scalar(file, value, "Expression") {
+				if _, err := compileexpression(value.Value); err != nil {
+					v.report(at(file, value), "invalid expression (%s)", err)
+				}
+			}
+		case "Captures":
+			captures = value
+			if v.scalar(file, value, "Captures") {
+				if _, err := parsecaptures(value.Value); err != nil {
+					v.report(at(file, value), "invalid captures (%s)", err)
+				}
+			}
+		case "ACL":
+			v.mapping(file, value, "ACL", func(subject *yaml.Node, access *yaml.Node) {
+				has_acl = true
+				v.references = append(v.references, reference{subject.Value, at(file, subject)})
+				v.verbs(file, access, "the access of "+subject.Value)
+			})
+		default:
+			v.report(at(file, key), "unknown key %s", key.Value)
+		}
+	})
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+
+	if !has_url {
+		v.report(at(file, n), "rule without Url")
+	}
+	if captures != nil && !has_expression {
+		v.report(at(file, captures), "Captures without an Expression")
+	}
+	if url != "" && unconditional && has_acl {
+		if p, exists := v.urls[rulekey(url)]; exists {
+			v.report(at(file, n), "duplicate rule for %s, first defined at %s", raw, p)
+		} else {
+			v.urls[rulekey(url)] = at(file, n)
+		}
+	}
+}
+
+func (v *validator) test(file string, n *yaml.Node) {
+	var has_url, has_expect bool
+
+	v.mapping(file, n, "a test", func(key *yaml.Node, value *yaml.Node) {
+		switch key.Value {
+		case "name", "subject", "remote_addr":
+			v.scalar(file, value, key.Value)
+		case "method":
+			if v.scalar(file, value, "method") {
+				if _, err := accessflags([]string{strings.ToUpper(value.Value)}); err != nil {
+					v.report(at(file, value), "unknown HTTP verb %s", value.Value)
+				}
+			}
+		case "url":
+			has_url = true
+			if v.scalar(file, value, "url") {
+				if _, err := Canonicalize(value.Value, false); err != nil {
+					v.report(at(file, value), "invalid URL %s (%s)", value.Value, err)
+				}
+			}
+		case "header":
+			v.mapping(file, value, "header", func(name *yaml.Node, header *yaml.Node) {
+				v.scalar(file, header, "header "+name.Value)
+			})
+		case "expect":
+			has_expect = true
+			if v.scalar(file, value, "expect") && value.Value != "allow" && value.Value != "deny" {
+				v.report(at(file, value), "expect must be allow or deny, not %s", value.Value)
+			}
+		default:
+			v.report(at(file, key), "unknown key %s", key.Value)
+		}
+	})
+	if n.Kind != yaml.MappingNode {
+		return
+	}
+
+	if !has_url {
+		v.report(at(file, n), "test without url")
+	}
+	if !has_expect {
+		v.report(at(file, n), "test without expect")
+	}
+}
+
+//Checks the references between the files' definitions once all files are read
+func (v *validator) check() {
+	for name, p := range v.groups {
+		if v.members[name] {
+			v.report(p, "group name %s is also the name of a subject that is member of a group", name)
+		}
+		if _, exists := v.subjects[name]; exists {
+			v.report(p, "group name %s is also the name of a subject defined at %s", name, v.subjects[name])
+		}
+	}
+
+	for _, b := range v.bindings {
+		if _, exists := v.roles[b.name]; !exists {
+			v.report(b.at, "undefined role %s", b.name)
+		}
+	}
+
+	if len(v.subjects) == 0 {
+		return
+	}
+	for _, r := range v.references {
+		_, group := v.groups[r.name]
+		_, subject := v.subjects[r.name]
+		if !group && !subject && !v.members[r.name] {
+			v.report(r.at, "undefined group or subject %s", r.name)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package rulebase
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const validate_test_conf = `---
+title: "Configuration with problems"
+inheritance: merge
+colour: blue
+
+groups:
+  admins: [Jim, developers]
+  developers: [John]
+
+subjects:
+  Jim:
+    department: finance
+  admins: {}
+
+rules:
+  - Url: www.corp*.com/*
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/*
+    ACL:
+      Jim: [GET, FETCH]
+      John: allow
+  - Url: www.corpA.com/*
+    Window:
+      Hours: "25:00-26:00"
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/*
+    ACL:
+      auditors: [GET]
+  - Url: www.corpA.com/reports
+    Expression: subject.department ==
+    ACL:
+      Jim: [GET]
+  - Url: www.corpA.com/reports/*
+    Captures: "{id}"
+    Conditions:
+      Query:
+        id: "*"
+    ACL:
+      Jim: [GET]
+  - ACL:
+      Jim: [GET]
+
+role_bindings:
+  - Role: reader
+    Subjects: [Jim]
+    Scopes: [www.corpA.com/wiki/*]`
+
+func TestValidate(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": validate_test_conf})
+	filename := filepath.Join(dir, "conf.yml")
+
+	expected := []string{
+		"conf.yml:3:14: Unknown inheritance mode merge",
+		"conf.yml:4:1: unknown key colour",
+		"conf.yml:7:3: group name admins is also the name of a subject defined at ",
+		"conf.yml:8:3: group name developers is also the name of a subject that is member of a group",
+		"conf.yml:16:10: illegal wildcard in www.corp*.com/*, * is only allowed at the end of a URL",
+		"conf.yml:21:18: unknown HTTP verb FETCH",
+		"conf.yml:22:13: the access of John (a list of HTTP verbs) must be a list",
+		"conf.yml:25:7: invalid window (invalid time of day \"25:00\", expected 15:04)",
+		"conf.yml:28:5: duplicate rule for www.corpA.com/*, first defined at ",
+		"conf.yml:30:7: undefined group or subject auditors",
+		"conf.yml:32:17: invalid expression (unexpected end of expression at 21)",
+		"conf.yml:36:15: Captures without an Expression",
+		"conf.yml:42:5: rule without Url",
+		"conf.yml:46:11: undefined role reader",
+		"conf.yml:48:14: scope www.corpA.com/wiki/* must not end in a wildcard",
+	}
+
+	diagnostics := Validate(filename)
+	for i, d := range diagnostics {
+		message := strings.TrimPrefix(d.String(), dir+string(filepath.Separator))
+		if i >= len(expected) || !strings.HasPrefix(message, expected[i]) {
+			t.Errorf("Unexpected diagnostic %s", message)
+		}
+	}
+	if len(diagnostics) != len(expected) {
+		t.Errorf("Got %d diagnostics, expected %d", len(diagnostics), len(expected))
+	}
+}
+
+func TestValidateDuplicateWildcard(t *testing.T) {
+	//a wildcard rule and a rule for its prefix share their node in the tree
+	dir := writetestfiles(t, map[string]string{"conf.yml": "rules:\n  - Url: www.corpA.com/docs/*\n    ACL:\n      Jim: [GET]\n  - Url: www.corpA.com/docs/\n    ACL:\n      John: [GET]\n"})
+
+	diagnostics := Validate(filepath.Join(dir, "conf.yml"))
+	if len(diagnostics) != 1 || !strings.Contains(diagnostics[0].String(), "duplicate rule for www.corpA.com/docs/, first defined at ") {
+		t.Errorf("Wrong diagnostics %v for rules that differ only by the wildcard", diagnostics)
+	}
+}
+
+func TestValidateValid(t *testing.T) {
+	for name, conf := range map[string]string{
+		"test_conf":             test_conf,
+		"roles_test_conf":       roles_test_conf,
+		"expression_test_conf":  expression_test_conf,
+		"conditions_test_conf":  conditions_test_conf,
+		"inheritance_test_conf": inheritance_test_conf,
+		"defaults_test_conf":    defaults_test_conf,
+		"policytest_test_conf":  policytest_test_conf,
+	} {
+		dir := writetestfiles(t, map[string]string{"conf.yml": conf})
+		for _, d := range Validate(filepath.Join(dir, "conf.yml")) {
+			t.Errorf("%s: unexpected diagnostic %s", name, d)
+		}
+	}
+}
+
+func TestValidateSyntaxError(t *testing.T) {
+	dir := writetestfiles(t, map[string]string{"conf.yml": "rules:\n  - Url: x\n   ACL: [\n"})
+
+	diagnostics := Validate(filepath.Join(dir, "conf.yml"))
+	if len(diagnostics) != 1 || diagnostics[0].Line == 0 {
+		t.Errorf("Wrong diagnostics %v for a syntax error", diagnostics)
+	}
+}
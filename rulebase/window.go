@@ -0,0 +1,220 @@
+package rulebase
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+//Window limits a rule to a period of time. NotBefore and NotAfter bound the dates the rule is
+//valid on and are either dates (2006-01-02), in which case NotAfter includes the whole day, or
+//RFC 3339 timestamps. Weekdays and Hours ("22:00-06:00") restrict the rule to recurring
+//maintenance windows; an Hours range that ends before it starts wraps around midnight and
+//belongs to the weekday it starts on, one that ends when it starts is the whole day.
+//Location is the IANA time zone dates, weekdays and hours are interpreted in (UTC by default).
+type Window struct {
+	NotBefore string   `yaml:"NotBefore,omitempty"`
+	NotAfter  string   `yaml:"NotAfter,omitempty"`
+	Weekdays  []string `yaml:"Weekdays,omitempty"`
+	Hours     string   `yaml:"Hours,omitempty"`
+	Location  string   `yaml:"Location,omitempty"`
+}
+
+//A Window parsed into times and minutes of the day. spec is the Window it was parsed from.
+type window struct {
+	spec                  Window
+	not_before, not_after time.Time
+	weekdays              [7]bool
+	all_days              bool
+	from, to              int
+	all_day               bool
+	location              *time.Location
+}
+
+//A rule with a window or an expression but no conditions. Its ACL is granted on top of the ACL
+//stored in the tree for its URL while the window is open and the expression holds.
+type timed struct {
+	url        string
+	window     *window
+	expression *expression
+	captures   []string
+	keys       map[string]int
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+func (w *Window) parse() (*window, error) {
+	var err error
+	p := window{spec: *w, all_days: len(w.Weekdays) == 0, all_day: w.Hours == "", location: time.UTC}
+
+	if w.Location != "" {
+		p.location, err = time.LoadLocation(w.Location)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if w.NotBefore != "" {
+		p.not_before, _, err = parsedate(w.NotBefore, p.location)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if w.NotAfter != "" {
+		var date_only bool
+		p.not_after, date_only, err = parsedate(w.NotAfter, p.location)
+		if err != nil {
+			return nil, err
+		}
+		if date_only {
+			p.not_after = p.not_after.AddDate(0, 0, 1).Add(-time.Nanosecond)
+		}
+	}
+	if !p.not_before.IsZero() && !p.not_after.IsZero() && p.not_after.Before(p.not_before) {
+		return nil, errors.New(fmt.Sprintf("window ends (%s) before it starts (%s)", w.NotAfter, w.NotBefore))
+	}
+
+	for _, d := range w.Weekdays {
+		day, exists := weekdays[strings.ToLower(d)]
+		if !exists {
+			return nil, errors.New(fmt.Sprintf("Unknown weekday %s", d))
+		}
+		p.weekdays[day] = true
+	}
+
+	if w.Hours != "" {
+		hours := strings.SplitN(w.Hours, "-", 2)
+		if len(hours) != 2 {
+			return nil, errors.New(fmt.Sprintf("Hours %q must be a range like 22:00-06:00", w.Hours))
+		}
+		if p.from, err = parseclock(hours[0]); err != nil {
+			return nil, err
+		}
+		if p.to, err = parseclock(hours[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	return &p, nil
+}
+
+//Parses a date or an RFC 3339 timestamp and reports whether it was a date
+func parsedate(s string, location *time.Location) (time.Time, bool, error) {
+	if t, err := time.ParseInLocation("2006-01-02", s, location); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, false, errors.New(fmt.Sprintf("invalid date %q, expected 2006-01-02 or RFC 3339", s))
+	}
+	return t, false, nil
+}
+
+//Parses a time of day (15:04) into minutes since midnight
+func parseclock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, errors.New(fmt.Sprintf("invalid time of day %q, expected 15:04", s))
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+//Reports whether the window is open at now
+func (w *window) contains(now time.Time) bool {
+	now = now.In(w.location)
+
+	if !w.not_before.IsZero() && now.Before(w.not_before) {
+		return false
+	}
+	if !w.not_after.IsZero() && now.After(w.not_after) {
+		return false
+	}
+
+	//the weekdays are the days a range of hours starts on, the early hours of a range that wraps
+	//around midnight belong to the day before
+	day := now.Weekday()
+	if !w.all_day {
+		minute := now.Hour()*60 + now.Minute()
+		switch {
+		case w.from == w.to: //the whole day
+		case w.from < w.to:
+			if minute < w.from || minute >= w.to {
+				return false
+			}
+		case minute < w.to:
+			day = (day + 6) % 7
+		case minute < w.from:
+			return false
+		}
+	}
+
+	return w.all_days || w.weekdays[day]
+}
+
+//Reports whether the window can never open again after now
+func (w *window) expired(now time.Time) bool {
+	return !w.not_after.IsZero() && now.After(w.not_after)
+}
+
+//Grant describes a rule with a window, as reported by ExpiredGrants
+type Grant struct {
+	Url      string
+	Subjects []string
+	NotAfter time.Time
+}
+
+//Sets the clock used to evaluate rule windows. The default is time.Now.
+func (rb *Rulebase) SetClock(now func() time.Time) {
+	rb.now = now
+}
+
+//Returns the rules whose window has closed for good, e.g. temporary grants past their NotAfter
+//date. They no longer grant anything and can be removed from the configuration.
+func (rb Rulebase) ExpiredGrants() []Grant {
+	var expired []Grant
+
+	now := rb.now()
+	for _, grants := range rb.timed {
+		for _, g := range grants {
+			if g.window != nil && g.window.expired(now) {
+				expired = append(expired, Grant{Url: g.url, Subjects: sortedkeys(g.keys), NotAfter: g.window.not_after})
+			}
+		}
+	}
+	for _, conditionals := range rb.conditional {
+		for _, c := range conditionals {
+			if c.window != nil && c.window.expired(now) {
+				expired = append(expired, Grant{Url: c.url, Subjects: sortedkeys(c.keys), NotAfter: c.window.not_after})
+			}
+		}
+	}
+
+	sort.Slice(expired, func(i, j int) bool {
+		if expired[i].Url != expired[j].Url {
+			return expired[i].Url < expired[j].Url
+		} else if !expired[i].NotAfter.Equal(expired[j].NotAfter) {
+			return expired[i].NotAfter.Before(expired[j].NotAfter)
+		}
+		return strings.Join(expired[i].Subjects, ",") < strings.Join(expired[j].Subjects, ",")
+	})
+	return expired
+}
+
+func sortedkeys(m map[string]int) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,125 @@
+package rulebase
+
+import (
+	"testing"
+	"time"
+)
+
+const window_test_conf = `---
+title: "Rulebase with time bounded rules"
+
+rules:
+  - Url: www.corpA.com/admin*
+    ACL:
+      Jim: [GET, POST]
+  - Url: www.corpA.com/admin*
+    Window:
+      NotBefore: 2026-03-01
+      NotAfter: 2026-03-31
+    ACL:
+      contractor: [GET]
+  - Url: www.corpA.com/admin/maintenance
+    Window:
+      Weekdays: [Sat, sun]
+      Hours: "22:00-06:00"
+      Location: Europe/Berlin
+    ACL:
+      John: [POST]
+  - Url: www.corpA.com/admin/backup
+    Window:
+      Weekdays: [Wed]
+      Hours: "09:00-09:00"
+    ACL:
+      Jack: [PUT]
+  - Url: www.corpA.com/reports
+    Conditions:
+      Query:
+        export: "true"
+    Window:
+      NotAfter: 2026-01-31T12:00:00Z
+    ACL:
+      John: [GET]`
+
+//Returns a clock that always returns the time s (RFC 3339)
+func fixedclock(t *testing.T, s string) func() time.Time {
+	now, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func() time.Time { return now }
+}
+
+func TestAuthorizeWindow(t *testing.T) {
+	rb := createtestrulebase(t, window_test_conf)
+
+	cases := []struct {
+		now, subject, url string
+		access            int
+	}{
+		{"2026-02-28T23:59:59Z", "contractor", "www.corpA.com/admin/users", 0},
+		{"2026-03-01T00:00:00Z", "contractor", "www.corpA.com/admin/users", GET},
+		{"2026-03-31T23:59:59Z", "contractor", "www.corpA.com/admin/users", GET},
+		{"2026-04-01T00:00:00Z", "contractor", "www.corpA.com/admin/users", 0},
+		{"2026-03-15T10:00:00Z", "Jim", "www.corpA.com/admin/users", GET | POST},
+		//Saturday 23:30 and Sunday 05:59 in Berlin (CET, UTC+1)
+		{"2026-03-07T22:30:00Z", "John", "www.corpA.com/admin/maintenance", POST},
+		{"2026-03-08T04:59:00Z", "John", "www.corpA.com/admin/maintenance", POST},
+		//Sunday 07:00 and Friday 23:00 in Berlin
+		{"2026-03-08T06:00:00Z", "John", "www.corpA.com/admin/maintenance", 0},
+		{"2026-03-06T22:00:00Z", "John", "www.corpA.com/admin/maintenance", 0},
+		//Monday 05:00 in Berlin is in the range that started on Sunday, Saturday 05:00 in the one
+		//that started on Friday
+		{"2026-03-09T04:00:00Z", "John", "www.corpA.com/admin/maintenance", POST},
+		{"2026-03-07T04:00:00Z", "John", "www.corpA.com/admin/maintenance", 0},
+		//a range that ends when it starts is the whole day
+		{"2026-03-04T00:00:00Z", "Jack", "www.corpA.com/admin/backup", PUT},
+		{"2026-03-04T08:00:00Z", "Jack", "www.corpA.com/admin/backup", PUT},
+		{"2026-03-04T23:59:00Z", "Jack", "www.corpA.com/admin/backup", PUT},
+		{"2026-03-05T08:00:00Z", "Jack", "www.corpA.com/admin/backup", 0},
+		{"2026-01-31T11:59:00Z", "John", "www.corpA.com/reports?export=true", GET},
+		{"2026-01-31T12:01:00Z", "John", "www.corpA.com/reports?export=true", 0},
+	}
+
+	for _, c := range cases {
+		rb.SetClock(fixedclock(t, c.now))
+		access, err := rb.Lookup(c.subject, c.url)
+		if err != nil {
+			t.Errorf("Lookup %s:%s at %s failed (%s)", c.subject, c.url, c.now, err)
+		} else if access != c.access {
+			t.Errorf("Wrong authorization value %d for %s:%s at %s, should be %d", access, c.subject, c.url, c.now, c.access)
+		}
+	}
+}
+
+func TestExpiredGrants(t *testing.T) {
+	rb := createtestrulebase(t, window_test_conf)
+
+	rb.SetClock(fixedclock(t, "2026-02-15T00:00:00Z"))
+	expired := rb.ExpiredGrants()
+	if len(expired) != 1 || expired[0].Url != "www.corpa.com/reports" {
+		t.Errorf("Only the www.corpA.com/reports grant should have expired, not %v", expired)
+	}
+
+	rb.SetClock(fixedclock(t, "2026-04-01T00:00:00Z"))
+	expired = rb.ExpiredGrants()
+	if len(expired) != 2 || expired[0].Url != "www.corpa.com/admin*" || expired[0].Subjects[0] != "contractor" {
+		t.Errorf("The www.corpA.com/admin* and www.corpA.com/reports grants should have expired, not %v", expired)
+	}
+}
+
+func TestInvalidWindow(t *testing.T) {
+	for _, w := range []Window{
+		{NotBefore: "2026-04-01", NotAfter: "2026-03-01"},
+		{NotAfter: "31.03.2026"},
+		{Weekdays: []string{"Caturday"}},
+		{Hours: "22:00"},
+		{Hours: "22:00-25:00"},
+		{Location: "Mars/Olympus_Mons"},
+	} {
+		rb := New()
+		err := rb.Add(&Rule{Url: "www.corpA.com/admin", Window: &w, ACL: map[string][]string{"Jim": {"GET"}}})
+		if err == nil {
+			t.Errorf("Adding a rule with window %+v should fail", w)
+		}
+	}
+}